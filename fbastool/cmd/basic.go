@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"fmt"
 	"io"
 	"os"
 
@@ -42,11 +43,56 @@ var basicCmd = &cobra.Command{
 		if err != nil {
 			panic(err)
 		}
+		source, err := cmd.PersistentFlags().GetBool("source")
+		if err != nil {
+			panic(err)
+		}
+		structured, err := cmd.PersistentFlags().GetBool("structured")
+		if err != nil {
+			panic(err)
+		}
+		keepComments, err := cmd.PersistentFlags().GetBool("keep-comments")
+		if err != nil {
+			panic(err)
+		}
+		dialect := getDialect(cmd)
 		if encMode {
 			data, err := os.ReadFile(args[0])
 			if err != nil {
 				panic(err)
 			}
+			var program *internal.FBProgram
+			if source || structured {
+				program, err = encodeSource(cmd, args[0], string(data), dialect)
+			} else {
+				var diags []internal.FBDiagnostic
+				program, diags = internal.FBPreprocessProgram(string(data), dialect, internal.FBSourceOptions{Filename: args[0], Include: includeFile, KeepComments: keepComments})
+				for _, d := range diags {
+					fmt.Fprintln(os.Stderr, d.Format(args[0]))
+				}
+				if errorCount := internal.FBDiagnosticErrorCount(diags); errorCount > 0 {
+					err = fmt.Errorf("%d errors found", errorCount)
+				}
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			data, err = program.MarshalBinary()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			// the header goes next to the program, as play -s writes it
+			if program.Header.HasFileInfo() && outFile != "-" {
+				infoData, err := program.Header.FileInfo(len(data)).MarshalBinary()
+				if err != nil {
+					panic(err)
+				}
+				if err := os.WriteFile(outFile+".info", infoData, 0644); err != nil {
+					panic(err)
+				}
+			}
 			var fp io.Writer
 			if outFile == "-" {
 				fp = os.Stdout
@@ -58,10 +104,7 @@ var basicCmd = &cobra.Command{
 				defer file.Close()
 				fp = file
 			}
-			err = internal.FBBasicStringToBin(string(data), fp)
-			if err != nil {
-				panic(err)
-			}
+			fp.Write(data)
 		} else {
 			infp, err := os.Open(args[0])
 			if err != nil {
@@ -78,17 +121,90 @@ var basicCmd = &cobra.Command{
 				defer file.Close()
 				fp = file
 			}
-			outstr, err := internal.FBBasicBinToString(infp)
+			outstr, err := internal.FBBasicBinToString(infp, dialect)
 			if err != nil {
-				panic(err)
+				fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+				os.Exit(1)
+			}
+			if info, ok := readFileInfo(args[0]); ok {
+				outstr = internal.FBHeaderFromFileInfo(info).String() + outstr
 			}
 			fp.Write([]byte(outstr))
 		}
 	},
 }
 
+// encodeSource tokenizes a program in source form, with labels and
+// lines without numbers.
+func encodeSource(cmd *cobra.Command, filename string, text string, dialect internal.FBDialect) (*internal.FBProgram, error) {
+	opts := internal.FBSourceOptions{
+		Filename: filename,
+		Include:  includeFile,
+	}
+	var err error
+	if opts.Start, err = cmd.PersistentFlags().GetInt("start"); err != nil {
+		panic(err)
+	}
+	if opts.Step, err = cmd.PersistentFlags().GetInt("step"); err != nil {
+		panic(err)
+	}
+	if opts.Structured, err = cmd.PersistentFlags().GetBool("structured"); err != nil {
+		panic(err)
+	}
+	if opts.KeepComments, err = cmd.PersistentFlags().GetBool("keep-comments"); err != nil {
+		panic(err)
+	}
+	mapFile, err := cmd.PersistentFlags().GetString("map")
+	if err != nil {
+		panic(err)
+	}
+
+	program, sourceMap, diags := internal.FBCompileSource(text, dialect, opts)
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d.Format(filename))
+	}
+	if errorCount := internal.FBDiagnosticErrorCount(diags); errorCount > 0 {
+		return nil, fmt.Errorf("%d errors found", errorCount)
+	}
+	if mapFile != "" {
+		if err := os.WriteFile(mapFile, []byte(sourceMap.String()), 0644); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
+
+// dialectUsage describes the --dialect flag of commands with no more to
+// say about it.
+const dialectUsage = "Family BASIC version: v1, v2.0, v2.1 or v3"
+
+// addDialectFlag registers the --dialect flag read by getDialect.
+func addDialectFlag(cmd *cobra.Command, usage string) {
+	cmd.PersistentFlags().String("dialect", "v3", usage)
+}
+
+func getDialect(cmd *cobra.Command) internal.FBDialect {
+	name, err := cmd.PersistentFlags().GetString("dialect")
+	if err != nil {
+		panic(err)
+	}
+	dialect, err := internal.ParseFBDialect(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--dialect: %v\n", err)
+		os.Exit(1)
+	}
+	return dialect
+}
+
 func init() {
 	rootCmd.AddCommand(basicCmd)
 	basicCmd.PersistentFlags().StringP("output", "o", "-", "Output file")
 	basicCmd.PersistentFlags().BoolP("encode", "e", false, "Encode to binary")
+	addDialectFlag(basicCmd, dialectUsage)
+	basicCmd.PersistentFlags().Bool("source", false, "Encode from source form, with labels (@name:) and lines without numbers")
+	basicCmd.PersistentFlags().Bool("structured", false, "Encode from structured source: source form with block IF, WHILE, SUB and long names")
+	basicCmd.PersistentFlags().Bool("keep-comments", false, "Encode //! comments as REM statements")
+	basicCmd.PersistentFlags().Int("start", 10, "Source form: number of the first line")
+	basicCmd.PersistentFlags().Int("step", 10, "Source form: step between line numbers")
+	basicCmd.PersistentFlags().String("map", "", "Source form: write the source line of each program line to this file")
 }
@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Build an index of a directory of tape captures and extracted files",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outFile, err := cmd.PersistentFlags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		htmlFile, err := cmd.PersistentFlags().GetString("html")
+		if err != nil {
+			panic(err)
+		}
+		previewLines, err := cmd.PersistentFlags().GetInt("preview-lines")
+		if err != nil {
+			panic(err)
+		}
+
+		catalog, err := internal.BuildCatalog(args[0], previewLines)
+		if err != nil {
+			panic(err)
+		}
+
+		var fp io.Writer
+		if outFile == "-" {
+			fp = os.Stdout
+		} else {
+			file, err := os.Create(outFile)
+			if err != nil {
+				panic(err)
+			}
+			defer file.Close()
+			fp = file
+		}
+		encoder := json.NewEncoder(fp)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(catalog)
+		if err != nil {
+			panic(err)
+		}
+
+		if htmlFile != "" {
+			file, err := os.Create(htmlFile)
+			if err != nil {
+				panic(err)
+			}
+			defer file.Close()
+			err = catalog.WriteHTML(file)
+			if err != nil {
+				panic(err)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.PersistentFlags().StringP("output", "o", "-", "Output JSON file")
+	catalogCmd.PersistentFlags().String("html", "", "Also write an HTML index to this file")
+	catalogCmd.PersistentFlags().Int("preview-lines", 10, "Lines of BASIC listing to include in the preview")
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// checksumCmd represents the checksum command
+var checksumCmd = &cobra.Command{
+	Use:   "checksum",
+	Short: "Print or check per-line type-in codes of a BASIC program (.prg or text)",
+	Args:  cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		algorithm, err := cmd.PersistentFlags().GetString("algorithm")
+		if err != nil {
+			panic(err)
+		}
+		codesOnly, err := cmd.PersistentFlags().GetBool("codes")
+		if err != nil {
+			panic(err)
+		}
+		checkFile, err := cmd.PersistentFlags().GetString("check")
+		if err != nil {
+			panic(err)
+		}
+		checker, err := cmd.PersistentFlags().GetBool("checker")
+		if err != nil {
+			panic(err)
+		}
+		start, err := cmd.PersistentFlags().GetInt("start")
+		if err != nil {
+			panic(err)
+		}
+
+		sum, err := internal.FBLineChecksumByName(algorithm)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if checker {
+			listing, err := internal.FBChecksumChecker(sum, start)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Print(listing)
+			return
+		}
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "expected a program")
+			os.Exit(1)
+		}
+
+		program := readProgram(args[0], getDialect(cmd))
+		if checkFile != "" {
+			f, err := os.Open(checkFile)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			expected, err := internal.ReadFBChecksums(f)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", checkFile, err)
+				os.Exit(1)
+			}
+			problems := program.CheckChecksums(sum, expected)
+			for _, p := range problems {
+				fmt.Printf("%s: %s\n", args[0], p)
+			}
+			if len(problems) > 0 {
+				os.Exit(1)
+			}
+		} else if codesOnly {
+			program.WriteChecksums(os.Stdout, sum)
+		} else {
+			program.WriteChecksumListing(os.Stdout, sum)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checksumCmd)
+	checksumCmd.PersistentFlags().String("algorithm", internal.DefaultFBLineChecksum, "Checksum algorithm: sum or fb8")
+	checksumCmd.PersistentFlags().Bool("codes", false, "Print only line numbers and codes, as read by --check")
+	checksumCmd.PersistentFlags().String("check", "", "Compare against a file of expected codes, reporting lines which differ")
+	checksumCmd.PersistentFlags().Bool("checker", false, "Print a Family BASIC program which shows the codes on the machine")
+	checksumCmd.PersistentFlags().Int("start", 32000, "First line number of the checker program")
+	addDialectFlag(checksumCmd, dialectUsage)
+}
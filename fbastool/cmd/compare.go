@@ -0,0 +1,179 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare A.txt B.txt",
+	Short: "Compare two transcriptions of the same listing, token by token",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		outFile, err := cmd.PersistentFlags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		prefer, err := cmd.PersistentFlags().GetString("prefer")
+		if err != nil {
+			panic(err)
+		}
+		if prefer != "" && prefer != "a" && prefer != "b" {
+			fmt.Fprintf(os.Stderr, "--prefer: invalid choice %s, expected a or b\n", prefer)
+			os.Exit(1)
+		}
+		context, err := cmd.PersistentFlags().GetInt("context")
+		if err != nil {
+			panic(err)
+		}
+		dialect := getDialect(cmd)
+		resolutions := getResolutions(cmd)
+
+		var texts [2]string
+		var programs [2]*internal.FBProgram
+		for i := range programs {
+			data, err := os.ReadFile(args[i])
+			if err != nil {
+				panic(err)
+			}
+			texts[i] = string(data)
+			var diags []internal.FBDiagnostic
+			programs[i], diags = internal.FBParseProgramWithOptions(texts[i], dialect, internal.FBParseOptions{UpperCase: true})
+			for _, d := range diags {
+				fmt.Fprintln(os.Stderr, d.Format(args[i]))
+			}
+			if errorCount := internal.FBDiagnosticErrorCount(diags); errorCount > 0 {
+				fmt.Fprintf(os.Stderr, "%d errors found\n", errorCount)
+				os.Exit(1)
+			}
+		}
+
+		diffs := internal.FBCompare(programs[0], programs[1])
+		printComparison(diffs, context)
+
+		merged, unresolved := internal.FBMergeListings(texts[0], texts[1], [2]string{args[0], args[1]}, diffs, func(d internal.FBLineDiff) string {
+			if choice, ok := resolutions[d.Number]; ok {
+				return choice
+			}
+			return prefer
+		})
+
+		if outFile != "" {
+			var fp io.Writer
+			if outFile == "-" {
+				fp = os.Stdout
+			} else {
+				file, err := os.Create(outFile)
+				if err != nil {
+					panic(err)
+				}
+				defer file.Close()
+				fp = file
+			}
+			fp.Write([]byte(merged))
+		}
+		if unresolved > 0 {
+			fmt.Fprintf(os.Stderr, "%d unresolved differences\n", unresolved)
+			os.Exit(1)
+		}
+	},
+}
+
+// getResolutions reads the --take flag, a list of LINE=a or LINE=b.
+func getResolutions(cmd *cobra.Command) map[int]string {
+	takes, err := cmd.PersistentFlags().GetStringSlice("take")
+	if err != nil {
+		panic(err)
+	}
+	resolutions := make(map[int]string)
+	for _, take := range takes {
+		parts := strings.SplitN(take, "=", 2)
+		line, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 || (parts[1] != "a" && parts[1] != "b") {
+			fmt.Fprintf(os.Stderr, "--take: invalid resolution %s, expected LINE=a or LINE=b\n", take)
+			os.Exit(1)
+		}
+		resolutions[line] = parts[1]
+	}
+	return resolutions
+}
+
+// printComparison prints the differing lines side by side, along with up
+// to context equal lines around them.
+func printComparison(diffs []internal.FBLineDiff, context int) {
+	shown := make([]bool, len(diffs))
+	for i, d := range diffs {
+		if d.Equal() {
+			continue
+		}
+		for j := i - context; j <= i+context; j++ {
+			if j >= 0 && j < len(diffs) {
+				shown[j] = true
+			}
+		}
+	}
+
+	width := 0
+	for i, d := range diffs {
+		left, _ := d.SideBySide()
+		if n := utf8.RuneCountInString(left); shown[i] && n > width {
+			width = n
+		}
+	}
+
+	for i, d := range diffs {
+		if !shown[i] {
+			continue
+		}
+		if i > 0 && !shown[i-1] {
+			fmt.Println("...")
+		}
+		marker := " "
+		if d.A == nil {
+			marker = ">"
+		} else if d.B == nil {
+			marker = "<"
+		} else if !d.Equal() {
+			marker = "!"
+		}
+		left, right := d.SideBySide()
+		fmt.Printf("%s %s%s | %s\n", marker, left, strings.Repeat(" ", width-utf8.RuneCountInString(left)), right)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.PersistentFlags().StringP("output", "o", "", "Write the merged listing to this file")
+	compareCmd.PersistentFlags().StringSlice("take", nil, "Resolve a difference by taking the line from A or B, as in 20=b")
+	compareCmd.PersistentFlags().String("prefer", "", "Resolve all other differences by taking lines from a or b")
+	compareCmd.PersistentFlags().Int("context", 1, "Equal lines shown around each difference")
+	addDialectFlag(compareCmd, dialectUsage)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// crunchCmd represents the crunch command
+var crunchCmd = &cobra.Command{
+	Use:   "crunch",
+	Short: "Make a BASIC program (.prg or text) smaller",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outFile, err := cmd.PersistentFlags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		var opts internal.FBCrunchOptions
+		if opts.Spaces, err = cmd.PersistentFlags().GetBool("spaces"); err != nil {
+			panic(err)
+		}
+		if opts.Merge, err = cmd.PersistentFlags().GetBool("merge"); err != nil {
+			panic(err)
+		}
+		if opts.Rename, err = cmd.PersistentFlags().GetBool("rename"); err != nil {
+			panic(err)
+		}
+		if opts.MaxLength, err = cmd.PersistentFlags().GetInt("max-length"); err != nil {
+			panic(err)
+		}
+		comments, err := cmd.PersistentFlags().GetString("comments")
+		if err != nil {
+			panic(err)
+		}
+		opts.Comments, err = internal.ParseFBCommentMode(comments)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		program := readProgram(args[0], getDialect(cmd))
+		size := program.Size()
+		for _, step := range program.Crunch(opts) {
+			fmt.Fprintf(os.Stderr, "%-16s %5d bytes saved\n", step.Name+":", step.Saved)
+		}
+		fmt.Fprintf(os.Stderr, "%-16s %5d -> %d bytes\n", "total:", size, program.Size())
+
+		if outFile == "" {
+			outFile = "-"
+		}
+		writeProgram(program, outFile, outFile != "-" && isBinaryProgram(outFile))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(crunchCmd)
+	crunchCmd.PersistentFlags().StringP("output", "o", "", "Output file, .prg for a tokenized program (default: listing to standard output)")
+	crunchCmd.PersistentFlags().Bool("spaces", true, "Remove spaces which are not needed")
+	crunchCmd.PersistentFlags().String("comments", "drop", "What to do with REM and ' comments: keep, shorten or drop")
+	crunchCmd.PersistentFlags().Bool("merge", true, "Merge lines which are not jumped to into the line before")
+	crunchCmd.PersistentFlags().Bool("rename", true, "Shorten variable names")
+	crunchCmd.PersistentFlags().Int("max-length", internal.FBMaxEditorLineLength, "Longest merged line, in characters as typed in")
+	addDialectFlag(crunchCmd, dialectUsage)
+}
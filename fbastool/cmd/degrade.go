@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+var degradeCmd = &cobra.Command{
+	Use:   "degrade",
+	Short: "Apply simulated tape wear to a WAV file",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		flags := cmd.PersistentFlags()
+		severity, err := flags.GetFloat64("severity")
+		if err != nil {
+			panic(err)
+		}
+		seed, err := flags.GetInt64("seed")
+		if err != nil {
+			panic(err)
+		}
+
+		options := internal.DegradeSeverity(severity)
+		overrides := map[string]*float64{
+			"noise":         &options.WhiteNoise,
+			"hum":           &options.Hum,
+			"hum-frequency": &options.HumFrequency,
+			"dc":            &options.DCWander,
+			"wow":           &options.Wow,
+			"flutter":       &options.Flutter,
+			"dropouts":      &options.Dropouts,
+			"dropout-depth": &options.DropoutDepth,
+			"clip":          &options.Clipping,
+			"lowpass":       &options.LowPass,
+			"flips":         &options.PolarityFlips,
+		}
+		for name, value := range overrides {
+			if flags.Changed(name) {
+				*value, err = flags.GetFloat64(name)
+				if err != nil {
+					panic(err)
+				}
+			}
+		}
+		if flags.Changed("invert") {
+			options.Invert, err = flags.GetBool("invert")
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		inpFile, err := os.Open(args[0])
+		if err != nil {
+			panic(err)
+		}
+		defer inpFile.Close()
+
+		outFile, err := os.Create(args[1])
+		if err != nil {
+			panic(err)
+		}
+		defer outFile.Close()
+
+		err = internal.DegradeTape(inpFile, outFile, options, seed)
+		if err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(degradeCmd)
+	degradeCmd.PersistentFlags().Float64("severity", 0, "Severity of all effects, from 0 to 1")
+	degradeCmd.PersistentFlags().Int64("seed", 1, "Random seed")
+	degradeCmd.PersistentFlags().Float64("noise", 0, "White noise level")
+	degradeCmd.PersistentFlags().Float64("hum", 0, "Mains hum level")
+	degradeCmd.PersistentFlags().Float64("hum-frequency", 50, "Mains hum frequency, in Hz")
+	degradeCmd.PersistentFlags().Float64("dc", 0, "DC wander level")
+	degradeCmd.PersistentFlags().Float64("wow", 0, "Wow depth (0.01 = 1% speed variation)")
+	degradeCmd.PersistentFlags().Float64("flutter", 0, "Flutter depth")
+	degradeCmd.PersistentFlags().Float64("dropouts", 0, "Dropouts per second")
+	degradeCmd.PersistentFlags().Float64("dropout-depth", 0.9, "Attenuation during dropouts, from 0 to 1")
+	degradeCmd.PersistentFlags().Float64("clip", 0, "Clipping level")
+	degradeCmd.PersistentFlags().Float64("lowpass", 0, "Head wear low-pass cutoff, in Hz")
+	degradeCmd.PersistentFlags().Float64("flips", 0, "Polarity flips per second")
+	degradeCmd.PersistentFlags().Bool("invert", false, "Invert polarity")
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+func getSpacing(cmd *cobra.Command, name string) internal.FBSpacing {
+	s, err := cmd.PersistentFlags().GetString(name)
+	if err != nil {
+		panic(err)
+	}
+	spacing, err := internal.ParseFBSpacing(s)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	return spacing
+}
+
+// fmtCmd represents the fmt command
+var fmtCmd = &cobra.Command{
+	Use:   "fmt",
+	Short: "Format BASIC programs (.prg or text) into a canonical listing",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		write, err := cmd.PersistentFlags().GetBool("write")
+		if err != nil {
+			panic(err)
+		}
+		check, err := cmd.PersistentFlags().GetBool("check")
+		if err != nil {
+			panic(err)
+		}
+		opts := internal.FBFormatOptions{
+			Keywords:  getSpacing(cmd, "keywords"),
+			Operators: getSpacing(cmd, "operators"),
+		}
+		dialect := getDialect(cmd)
+
+		unformatted := false
+		for _, filename := range args {
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				panic(err)
+			}
+
+			var program *internal.FBProgram
+			var formatted []byte
+			binary := isBinaryProgram(filename)
+			if binary {
+				program = readProgram(filename, dialect)
+				program.Respace(opts.Keywords, opts.Operators)
+				formatted, err = program.MarshalBinary()
+				if err != nil {
+					panic(err)
+				}
+			} else {
+				var diags []internal.FBDiagnostic
+				program, diags = internal.FBFormat(string(data), dialect, opts)
+				for _, d := range diags {
+					fmt.Fprintln(os.Stderr, d.Format(filename))
+				}
+				if errorCount := internal.FBDiagnosticErrorCount(diags); errorCount > 0 {
+					fmt.Fprintf(os.Stderr, "%d errors found\n", errorCount)
+					os.Exit(1)
+				}
+				formatted = []byte(program.String())
+			}
+
+			if check {
+				if !bytes.Equal(data, formatted) {
+					fmt.Println(filename)
+					unformatted = true
+				}
+			} else if write {
+				if !bytes.Equal(data, formatted) {
+					writeProgram(program, filename, binary)
+				}
+			} else {
+				fmt.Print(program.String())
+			}
+		}
+		if unformatted {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fmtCmd)
+	fmtCmd.PersistentFlags().BoolP("write", "w", false, "Write the result back to the file instead of printing it")
+	fmtCmd.PersistentFlags().Bool("check", false, "Only list files which are not formatted, exiting with an error if there are any")
+	fmtCmd.PersistentFlags().String("keywords", "keep", "Spaces around keywords: keep, none or around (changes the program bytes)")
+	fmtCmd.PersistentFlags().String("operators", "keep", "Spaces around operators: keep, none or around (changes the program bytes)")
+	addDialectFlag(fmtCmd, dialectUsage)
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check a BASIC program (.prg or text) for common mistakes",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		maxLength, err := cmd.PersistentFlags().GetInt("max-length")
+		if err != nil {
+			panic(err)
+		}
+		confusables, err := cmd.PersistentFlags().GetBool("confusables")
+		if err != nil {
+			panic(err)
+		}
+
+		dialect := getDialect(cmd)
+		if isBinaryProgram(args[0]) {
+			diags := readProgram(args[0], dialect).Lint(maxLength)
+			printLintDiagnostics(args[0], diags)
+			return
+		}
+
+		// confusables are a common cause of parse errors, so they are
+		// reported along with them
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			panic(err)
+		}
+		program, diags := internal.FBPreprocessProgram(string(data), dialect, internal.FBSourceOptions{Filename: args[0], Include: includeFile})
+		if internal.FBDiagnosticErrorCount(diags) == 0 {
+			diags = append(diags, program.Lint(maxLength)...)
+		}
+		if confusables {
+			diags = append(internal.FBCheckConfusables(string(data), program.Dialect), diags...)
+		}
+		sort.SliceStable(diags, func(i, j int) bool {
+			if diags[i].Line != diags[j].Line {
+				return diags[i].Line < diags[j].Line
+			}
+			return diags[i].Column < diags[j].Column
+		})
+		printLintDiagnostics(args[0], diags)
+	},
+}
+
+// printLintDiagnostics prints the problems found in a program, exiting with
+// an error if there are any.
+func printLintDiagnostics(filename string, diags []internal.FBDiagnostic) {
+	for _, d := range diags {
+		fmt.Println(d.Format(filename))
+	}
+	if len(diags) > 0 {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.PersistentFlags().Int("max-length", internal.FBMaxEditorLineLength, "Longest line the screen editor accepts, in characters")
+	lintCmd.PersistentFlags().Bool("confusables", true, "Also look for characters easily mistaken for others, such as O for 0")
+	addDialectFlag(lintCmd, dialectUsage)
+}
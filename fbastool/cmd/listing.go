@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// listingCmd represents the listing command
+var listingCmd = &cobra.Command{
+	Use:   "listing",
+	Short: "Render a BASIC program (.prg or text) as a printable HTML type-in listing",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outFile, err := cmd.PersistentFlags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		title, err := cmd.PersistentFlags().GetString("title")
+		if err != nil {
+			panic(err)
+		}
+		rows, err := cmd.PersistentFlags().GetInt("rows")
+		if err != nil {
+			panic(err)
+		}
+		checksum, err := cmd.PersistentFlags().GetString("checksum")
+		if err != nil {
+			panic(err)
+		}
+		fontFile, err := cmd.PersistentFlags().GetString("font")
+		if err != nil {
+			panic(err)
+		}
+
+		opts := internal.FBListingOptions{Title: title, RowsPerPage: rows}
+		if checksum != "" {
+			opts.Checksum, err = internal.FBLineChecksumByName(checksum)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		}
+		if fontFile != "" {
+			fp, err := os.Open(fontFile)
+			if err != nil {
+				panic(err)
+			}
+			opts.Font, err = internal.ReadFBFont(fp)
+			fp.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", fontFile, err)
+				os.Exit(1)
+			}
+		}
+
+		program := readProgram(args[0], getDialect(cmd))
+		if opts.Title == "" {
+			opts.Title = program.Header.Title
+		}
+		if opts.Title == "" {
+			opts.Title = filepath.Base(args[0])
+		}
+		out := os.Stdout
+		if outFile != "-" {
+			out, err = os.Create(outFile)
+			if err != nil {
+				panic(err)
+			}
+			defer out.Close()
+		}
+		if err := program.WriteListingHTML(out, opts); err != nil {
+			panic(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listingCmd)
+	listingCmd.PersistentFlags().StringP("output", "o", "-", "Output file")
+	listingCmd.PersistentFlags().String("title", "", "Title printed on each page (default: the listing's #title, or the file name)")
+	listingCmd.PersistentFlags().Int("rows", 60, "Screen rows per page")
+	listingCmd.PersistentFlags().String("checksum", "", "Also print per-line codes: sum or fb8")
+	listingCmd.PersistentFlags().String("font", "", "Character set PNG (16x16 characters of 8x8 pixels) to draw graphic characters with")
+	addDialectFlag(listingCmd, dialectUsage)
+}
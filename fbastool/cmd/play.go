@@ -21,10 +21,12 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/asiekierka/type-in-tools/fbastool/internal"
 	"github.com/spf13/cobra"
@@ -41,6 +43,16 @@ var playCmd = &cobra.Command{
 			panic(err)
 		}
 
+		splitMode, err := cmd.PersistentFlags().GetBool("split")
+		if err != nil {
+			panic(err)
+		}
+		splitPadding, err := cmd.PersistentFlags().GetFloat64("split-padding")
+		if err != nil {
+			panic(err)
+		}
+		dialect := getDialect(cmd)
+
 		outPath := ""
 		if len(args) >= 2 {
 			outPath = args[1]
@@ -48,15 +60,27 @@ var playCmd = &cobra.Command{
 			outPath = "."
 		}
 
-		wavToBin(args[0], outPath, rawMode)
+		wavToBin(args[0], outPath, rawMode, splitMode, splitPadding, dialect)
 	},
 }
 
-func wavToBin(filename string, outPath string, rawMode bool) {
+func wavToBin(filename string, outPath string, rawMode bool, splitMode bool, splitPadding float64, dialect internal.FBDialect) {
 	fp, err := os.Open(filename)
 	if err != nil {
 		panic(err)
 	}
+	defer fp.Close()
+
+	markers, _ := internal.ReadTapeMarkers(fp)
+	var headerMarkers []internal.TapeMarker
+	for _, m := range markers {
+		if m.Block == internal.RawBlockInfo {
+			headerMarkers = append(headerMarkers, m)
+		}
+	}
+	if len(headerMarkers) > 0 {
+		fmt.Printf("found %d file markers\n", len(headerMarkers))
+	}
 
 	tapeEncInfo := internal.NewTapeEncodingInfo()
 	tapeReader, err := internal.NewTapeReader(fp, tapeEncInfo)
@@ -65,15 +89,36 @@ func wavToBin(filename string, outPath string, rawMode bool) {
 	}
 
 	var files []*internal.FBFile
+	var locations []internal.TapeFileLocation
 	filesByFilename := make(map[string][]*internal.FBFile)
 
 	for {
 		file, err := tapeReader.NextFile()
 		if err != nil {
 			fmt.Println(err)
-			break
+			// resume at the next marked file, if there is one
+			next, ok := internal.NextTapeMarker(markers, tapeReader.SamplePosition())
+			if !ok {
+				break
+			}
+			fmt.Printf("skipping to marker %s\n", next.Name)
+			err = tapeReader.SkipTo(int64(next.Position))
+			if err != nil {
+				break
+			}
+			continue
+		}
+		location := tapeReader.LastFileLocation()
+		for _, m := range internal.NewTapeMarkers(*file, location) {
+			if err := internal.CheckTapeMarker(m, markers); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+			}
+		}
+		if file.Info.Type == internal.FileTypeBasic {
+			checkBasicDialect(file, dialect)
 		}
 		files = append(files, file)
+		locations = append(locations, location)
 		filename := file.Info.NameStr()
 		filesByFilename[filename] = append(filesByFilename[filename], file)
 	}
@@ -132,10 +177,47 @@ func wavToBin(filename string, outPath string, rawMode bool) {
 			}
 		}
 	}
+
+	if splitMode {
+		padding := int64(splitPadding * float64(tapeReader.SampleRate()))
+		indices := make(map[string]int)
+		for i, file := range files {
+			filename := file.Info.NameStr()
+			suffix := ".wav"
+			if len(filesByFilename[filename]) >= 2 {
+				suffix = "_" + strconv.Itoa(indices[filename]) + suffix
+			}
+			indices[filename]++
+
+			f, err := os.Create(filepath.Join(outPath, filename+suffix))
+			if err != nil {
+				panic(err)
+			}
+			err = internal.CopyWavSegment(fp, f, locations[i].Start-padding, locations[i].End+padding, internal.NewTapeMarkers(*file, locations[i]))
+			if err != nil {
+				panic(err)
+			}
+			f.Close()
+		}
+	}
+}
+
+// checkBasicDialect warns if a program uses keywords the dialect lacks.
+func checkBasicDialect(file *internal.FBFile, dialect internal.FBDialect) {
+	program, err := internal.FBReadProgram(bytes.NewReader(file.Data), internal.DefaultFBDialect)
+	if err != nil {
+		return
+	}
+	if keywords := program.UnsupportedKeywords(dialect); len(keywords) > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s uses keywords not available in Family BASIC %v: %s\n", file.Info.NameStr(), dialect, strings.Join(keywords, ", "))
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(playCmd)
 	playCmd.PersistentFlags().BoolP("encode", "e", false, "Encoding mode")
 	playCmd.PersistentFlags().BoolP("raw", "r", false, "Store raw metadata and preserve split files")
+	playCmd.PersistentFlags().BoolP("split", "s", false, "Also cut the capture into one WAV file per tape file")
+	playCmd.PersistentFlags().Float64("split-padding", 0.5, "Audio kept before and after each split file, in seconds")
+	addDialectFlag(playCmd, "Family BASIC version the tape was made for; warns about keywords it lacks")
 }
@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+)
+
+// isBinaryProgram returns true if the file holds a tokenized program,
+// rather than a listing.
+func isBinaryProgram(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".prg" || ext == ".bin"
+}
+
+// readProgram reads a tokenized program or a listing. Problems found in a
+// listing are printed; the program exits if any of them are errors.
+func readProgram(filename string, dialect internal.FBDialect) *internal.FBProgram {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		panic(err)
+	}
+	if isBinaryProgram(filename) {
+		program, err := internal.FBReadProgram(bytes.NewReader(data), dialect)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			os.Exit(1)
+		}
+		return program
+	}
+
+	return parseListing(filename, string(data), dialect)
+}
+
+// readFileInfo reads the tape file information kept next to a file, as
+// written by basic -e and play -r, returning false if there is none. The
+// program exits if it cannot be read.
+func readFileInfo(filename string) (internal.FBFileInfo, bool) {
+	var info internal.FBFileInfo
+	infoData, err := os.ReadFile(filename + ".info")
+	if err != nil {
+		return info, false
+	}
+	if err := info.UnmarshalBinary(infoData); err != nil {
+		fmt.Fprintf(os.Stderr, "%s.info: %v\n", filename, err)
+		os.Exit(1)
+	}
+	return info, true
+}
+
+// includeFile reads a file named by an #include directive.
+func includeFile(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	return string(data), err
+}
+
+// parseListing tokenizes a listing, after running its preprocessor
+// directives, printing any problems found; the program exits if any of
+// them are errors.
+func parseListing(filename string, text string, dialect internal.FBDialect) *internal.FBProgram {
+	program, diags := internal.FBPreprocessProgram(text, dialect, internal.FBSourceOptions{Filename: filename, Include: includeFile})
+	for _, d := range diags {
+		fmt.Fprintln(os.Stderr, d.Format(filename))
+	}
+	if errorCount := internal.FBDiagnosticErrorCount(diags); errorCount > 0 {
+		fmt.Fprintf(os.Stderr, "%d errors found\n", errorCount)
+		os.Exit(1)
+	}
+	return program
+}
+
+// writeProgram writes a program to a file, or standard output if the
+// filename is "-", as a listing or tokenized.
+func writeProgram(program *internal.FBProgram, filename string, binary bool) {
+	var data []byte
+	if binary {
+		var err error
+		data, err = program.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		data = []byte(program.String())
+	}
+
+	var fp io.Writer
+	if filename == "-" {
+		fp = os.Stdout
+	} else {
+		file, err := os.Create(filename)
+		if err != nil {
+			panic(err)
+		}
+		defer file.Close()
+		fp = file
+	}
+	fp.Write(data)
+}
@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -50,24 +51,60 @@ var recordCmd = &cobra.Command{
 
 		argName, _ := cmd.PersistentFlags().GetString("name")
 
+		verifyMode, err := cmd.PersistentFlags().GetBool("verify")
+		if err != nil {
+			panic(err)
+		}
+		noise, err := cmd.PersistentFlags().GetFloat64("noise")
+		if err != nil {
+			panic(err)
+		}
+		seed, err := cmd.PersistentFlags().GetInt64("seed")
+		if err != nil {
+			panic(err)
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			panic(err)
+		}
+
 		ext := filepath.Ext(args[0])
 		info := internal.FBFileInfo{}
 		info.Reserved1 = 0
+		var header internal.FBHeader
 		if strings.HasSuffix(ext, "prg") {
 			info.Type = internal.FileTypeBasic
 			info.Length = 0
 			info.LoadAddress = 0x6006
 			info.ExecutionAddress = 0x2020
+			// as written by basic -e and play -s
+			if sidecar, ok := readFileInfo(args[0]); ok {
+				info = sidecar
+				header = internal.FBHeaderFromFileInfo(info)
+				info.Length = 0
+			}
 		} else if strings.HasSuffix(ext, "gfx") {
 			info.Type = internal.FileTypeBgGraphics
 			info.Length = 0x100
 			info.LoadAddress = 0x700
 			info.ExecutionAddress = 0x2000
+		} else if strings.EqualFold(ext, ".txt") || strings.EqualFold(ext, ".bas") {
+			// a listing, whose header describes the tape file
+			program := parseListing(args[0], string(data), internal.DefaultFBDialect)
+			data, err = program.MarshalBinary()
+			if err != nil {
+				panic(err)
+			}
+			header = program.Header
+			info = header.FileInfo(0)
 		}
 
 		tapeFileName := strings.TrimSuffix(filepath.Base(args[0]), ext)
 		if len(argName) > 0 {
 			tapeFileName = argName
+		} else if header.Name != "" {
+			tapeFileName = header.Name
 		} else {
 			if len(tapeFileName) <= 13 && info.Type == internal.FileTypeBgGraphics && !strings.HasSuffix(tapeFileName, " BG") {
 				tapeFileName += " BG"
@@ -75,11 +112,7 @@ var recordCmd = &cobra.Command{
 		}
 		info.SetName(tapeFileName)
 
-		inpFile, err := os.Open(args[0])
-		if err != nil {
-			panic(err)
-		}
-		defer inpFile.Close()
+		inpFile := bytes.NewReader(data)
 
 		outFile, err := os.Create(outFilename)
 		if err != nil {
@@ -92,18 +125,13 @@ var recordCmd = &cobra.Command{
 		if err != nil {
 			panic(err)
 		}
-		defer tapeWriter.Close()
 
 		if info.Length == 0 {
-			inpFileStat, err := inpFile.Stat()
-			if err != nil {
-				panic(err)
-			}
-
-			info.Length = uint16(inpFileStat.Size())
+			info.Length = uint16(len(data))
 		}
 
 		fbFile := internal.FBFile{Info: info}
+		var fbFiles []internal.FBFile
 		tapeWriter.WriteSilence(0.25)
 
 		buf := make([]byte, info.Length)
@@ -122,9 +150,29 @@ var recordCmd = &cobra.Command{
 			if err != nil {
 				panic(err)
 			}
+			fbFiles = append(fbFiles, internal.FBFile{Info: fbFile.Info, Data: append([]byte(nil), buf...)})
 		}
 
 		tapeWriter.WriteSilence(0.25)
+		err = tapeWriter.Close()
+		if err != nil {
+			panic(err)
+		}
+
+		if verifyMode {
+			verifyFile, err := os.Open(outFilename)
+			if err != nil {
+				panic(err)
+			}
+			defer verifyFile.Close()
+
+			err = internal.VerifyTape(verifyFile, fbFiles, internal.DegradeOptions{WhiteNoise: noise}, seed)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "verification failed: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("verified %d files\n", len(fbFiles))
+		}
 	},
 }
 
@@ -132,4 +180,7 @@ func init() {
 	rootCmd.AddCommand(recordCmd)
 	recordCmd.PersistentFlags().IntP("rate", "r", 32000, "Audio frequency")
 	recordCmd.PersistentFlags().String("name", "", "Output file name")
+	recordCmd.PersistentFlags().Bool("verify", false, "Decode the written audio and compare it with the input")
+	recordCmd.PersistentFlags().Float64("noise", 0, "Amount of white noise to add while verifying, relative to full scale")
+	recordCmd.PersistentFlags().Int64("seed", 1, "Random seed of the noise added while verifying")
 }
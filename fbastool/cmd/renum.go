@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// renumCmd represents the renum command
+var renumCmd = &cobra.Command{
+	Use:   "renum",
+	Short: "Renumber the lines of a BASIC program (.prg or text)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		outFile, err := cmd.PersistentFlags().GetString("output")
+		if err != nil {
+			panic(err)
+		}
+		start, err := cmd.PersistentFlags().GetInt("start")
+		if err != nil {
+			panic(err)
+		}
+		step, err := cmd.PersistentFlags().GetInt("step")
+		if err != nil {
+			panic(err)
+		}
+		first, err := cmd.PersistentFlags().GetInt("from")
+		if err != nil {
+			panic(err)
+		}
+		last, err := cmd.PersistentFlags().GetInt("to")
+		if err != nil {
+			panic(err)
+		}
+
+		program := readProgram(args[0], getDialect(cmd))
+		dangling, err := program.Renumber(start, step, first, last)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, r := range dangling {
+			fmt.Fprintf(os.Stderr, "warning: %v\n", r)
+		}
+		writeProgram(program, outFile, isBinaryProgram(args[0]))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(renumCmd)
+	renumCmd.PersistentFlags().StringP("output", "o", "-", "Output file")
+	renumCmd.PersistentFlags().Int("start", 10, "New number of the first renumbered line")
+	renumCmd.PersistentFlags().Int("step", 10, "Increment between renumbered lines")
+	renumCmd.PersistentFlags().Int("from", 0, "First line to renumber")
+	renumCmd.PersistentFlags().Int("to", 65535, "Last line to renumber")
+	addDialectFlag(renumCmd, dialectUsage)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+// sizeCmd represents the size command
+var sizeCmd = &cobra.Command{
+	Use:   "size",
+	Short: "Compare the size of a BASIC program (.prg or text) with the memory of each Family BASIC version",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		top, err := cmd.PersistentFlags().GetInt("top")
+		if err != nil {
+			panic(err)
+		}
+		free, err := cmd.PersistentFlags().GetInt("free")
+		if err != nil {
+			panic(err)
+		}
+		dialect := getDialect(cmd)
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			panic(err)
+		}
+		program := readProgram(args[0], dialect)
+
+		// keywords missing from a version are stored as letters there, so
+		// listings are encoded for each version separately
+		sizes := make(map[internal.FBDialect]int)
+		sizeFor := func(d internal.FBDialect) (int, bool) {
+			if isBinaryProgram(args[0]) {
+				return len(data), true
+			}
+			if size, ok := sizes[d]; ok {
+				return size, size >= 0
+			}
+			program, diags := internal.FBPreprocessProgram(string(data), d, internal.FBSourceOptions{Filename: args[0], Include: includeFile})
+			encoded, err := program.MarshalBinary()
+			if err != nil || internal.FBDiagnosticErrorCount(diags) > 0 {
+				sizes[d] = -1
+				return 0, false
+			}
+			sizes[d] = len(encoded)
+			return len(encoded), true
+		}
+
+		budgets := internal.FBMemoryBudgets
+		if free > 0 {
+			budgets = []internal.FBMemoryBudget{{Dialect: dialect, Mode: "--free", Free: free}}
+		}
+		if free <= 0 {
+			fmt.Println("estimated: the free areas are not measured on a machine; use --free with the figure shown by yours")
+		}
+		fmt.Printf("%-8s %-12s %6s %6s %6s\n", "version", "mode", "free", "used", "left")
+		for _, b := range budgets {
+			size, ok := sizeFor(b.Dialect)
+			if !ok {
+				fmt.Printf("%-8v %-12s %6d %6s\n", b.Dialect, b.Mode, b.Free, "-")
+				continue
+			}
+			note := ""
+			if size > b.Free {
+				note = "  too large"
+			}
+			fmt.Printf("%-8v %-12s %6d %6d %6d%s\n", b.Dialect, b.Mode, b.Free, size, b.Free-size, note)
+		}
+		if isBinaryProgram(args[0]) {
+			for _, b := range budgets {
+				if missing := program.UnsupportedKeywords(b.Dialect); len(missing) > 0 && b.Mode == budgets[0].Mode {
+					fmt.Printf("warning: uses keywords not available in Family BASIC %v: %s\n", b.Dialect, strings.Join(missing, ", "))
+				}
+			}
+		}
+
+		if top > 0 {
+			fmt.Printf("\nlargest lines (%v):\n", dialect)
+			for _, l := range program.LargestLines(top) {
+				fmt.Printf("%6d %4d bytes\n", l.Number, l.Size)
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sizeCmd)
+	sizeCmd.PersistentFlags().Int("top", 5, "Number of largest lines to list")
+	sizeCmd.PersistentFlags().Int("free", 0, "Compare against this many free bytes, as shown by the machine, instead of the estimates for each version")
+	addDialectFlag(sizeCmd, "Family BASIC version the largest lines are measured for (and --free applies to)")
+}
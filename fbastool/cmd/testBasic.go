@@ -36,12 +36,13 @@ var testBasicCmd = &cobra.Command{
 	Short: "Round-trip convert binary -> text -> binary",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		dialect := getDialect(cmd)
 		origProgBin, err := os.ReadFile(args[0])
 		if err != nil {
 			panic(err)
 		}
 		fmt.Printf("binary -> text\n")
-		progString, err := internal.FBBasicBinToString(bytes.NewReader(origProgBin))
+		progString, err := internal.FBBasicBinToString(bytes.NewReader(origProgBin), dialect)
 		if err != nil {
 			panic(err)
 		}
@@ -49,7 +50,10 @@ var testBasicCmd = &cobra.Command{
 		fmt.Printf("text -> binary\n")
 		var buf bytes.Buffer
 		w := bufio.NewWriter(&buf)
-		err = internal.FBBasicStringToBin(progString, w)
+		diags, err := internal.FBBasicStringToBin(progString, dialect, w)
+		for _, d := range diags {
+			fmt.Println(d.Format(""))
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -67,4 +71,5 @@ var testBasicCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(testBasicCmd)
+	addDialectFlag(testBasicCmd, dialectUsage)
 }
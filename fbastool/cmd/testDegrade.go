@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/asiekierka/type-in-tools/fbastool/internal"
+	"github.com/spf13/cobra"
+)
+
+var testDegradeCmd = &cobra.Command{
+	Use:   "testDegrade",
+	Short: "Report decode success rates of a clean WAV file across severity levels",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		steps, err := cmd.PersistentFlags().GetInt("steps")
+		if err != nil {
+			panic(err)
+		}
+		runs, err := cmd.PersistentFlags().GetInt("runs")
+		if err != nil {
+			panic(err)
+		}
+		seed, err := cmd.PersistentFlags().GetInt64("seed")
+		if err != nil {
+			panic(err)
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			panic(err)
+		}
+
+		tapeReader, err := internal.NewTapeReader(bytes.NewReader(data), internal.NewTapeEncodingInfo())
+		if err != nil {
+			panic(err)
+		}
+		var files []internal.FBFile
+		for {
+			file, err := tapeReader.NextFile()
+			if err != nil {
+				break
+			}
+			files = append(files, *file)
+		}
+		if len(files) == 0 {
+			panic(fmt.Errorf("no files found in %s", args[0]))
+		}
+		fmt.Printf("found %d files\n", len(files))
+
+		for step := 0; step <= steps; step++ {
+			severity := float64(step) / float64(steps)
+			successes := 0
+			for run := 0; run < runs; run++ {
+				err = internal.VerifyTape(bytes.NewReader(data), files, internal.DegradeSeverity(severity), seed+int64(run))
+				if err == nil {
+					successes++
+				}
+			}
+			fmt.Printf("severity %.2f: %d/%d decoded\n", severity, successes, runs)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testDegradeCmd)
+	testDegradeCmd.PersistentFlags().Int("steps", 10, "Number of severity steps")
+	testDegradeCmd.PersistentFlags().Int("runs", 10, "Runs per severity step")
+	testDegradeCmd.PersistentFlags().Int64("seed", 1, "Random seed of the first run")
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type CatalogEntry struct {
+	Source     string   `json:"source"`
+	Index      int      `json:"index"`
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Size       int      `json:"size"`
+	SHA256     string   `json:"sha256"`
+	ChecksumOK *bool    `json:"checksumOk,omitempty"`
+	Duplicates []string `json:"duplicates,omitempty"`
+	Preview    string   `json:"preview,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type Catalog struct {
+	Entries []CatalogEntry `json:"entries"`
+	Errors  []string       `json:"errors,omitempty"`
+}
+
+// Location identifies the entry within the catalog, as "source#index" for
+// files found on tapes.
+func (e CatalogEntry) Location() string {
+	if e.ChecksumOK != nil {
+		return e.Source + "#" + strconv.Itoa(e.Index)
+	}
+	return e.Source
+}
+
+func newCatalogEntry(source string, index int, info FBFileInfo, data []byte, previewLines int) CatalogEntry {
+	hash := sha256.Sum256(data)
+	entry := CatalogEntry{
+		Source: source,
+		Index:  index,
+		Name:   info.NameStr(),
+		Type:   info.Type.String(),
+		Size:   len(data),
+		SHA256: hex.EncodeToString(hash[:]),
+	}
+	if info.Type == FileTypeBasic && previewLines > 0 {
+		// a damaged program is still listed, with the reason it has no
+		// preview
+		text, err := FBBasicBinToString(bytes.NewReader(data), DefaultFBDialect)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			lines := strings.SplitAfter(text, "\n")
+			if len(lines) > previewLines {
+				lines = lines[:previewLines]
+			}
+			entry.Preview = strings.Join(lines, "")
+		}
+	}
+	return entry
+}
+
+func (c *Catalog) addCapture(source string, path string, previewLines int) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	markers, _ := ReadTapeMarkers(fp)
+	tapeReader, err := NewTapeReader(fp, NewTapeEncodingInfo())
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		start := tapeReader.SamplePosition()
+		file, err := tapeReader.NextFile()
+		if err != nil {
+			// a damaged file is listed under the name of its marker, and
+			// the files after it are read from the next marker on, as
+			// play does
+			m, ok := NextTapeMarker(markers, start-1)
+			if !ok || int64(m.Position) >= tapeReader.SamplePosition() {
+				break
+			}
+			checksumOK := false
+			c.Entries = append(c.Entries, CatalogEntry{Source: source, Index: i, Name: m.Name, Type: m.Type.String(), ChecksumOK: &checksumOK, Error: err.Error()})
+			next, ok := NextTapeMarker(markers, tapeReader.SamplePosition())
+			if !ok || tapeReader.SkipTo(int64(next.Position)) != nil {
+				break
+			}
+			continue
+		}
+		entry := newCatalogEntry(source, i, file.Info, file.Data, previewLines)
+		checksumOK := tapeReader.LastFileChecksumsValid()
+		entry.ChecksumOK = &checksumOK
+		c.Entries = append(c.Entries, entry)
+	}
+	return nil
+}
+
+func (c *Catalog) addExtractedFile(source string, path string, previewLines int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	info := FBFileInfo{}
+	infoData, err := os.ReadFile(path + ".info")
+	if err == nil {
+		err = info.UnmarshalBinary(infoData)
+		if err != nil {
+			return err
+		}
+	} else {
+		ext := filepath.Ext(path)
+		switch strings.ToLower(ext) {
+		case ".prg":
+			info.Type = FileTypeBasic
+		case ".gfx":
+			info.Type = FileTypeBgGraphics
+		}
+		info.SetName(strings.TrimSuffix(filepath.Base(path), ext))
+	}
+
+	c.Entries = append(c.Entries, newCatalogEntry(source, 0, info, data, previewLines))
+	return nil
+}
+
+// BuildCatalog indexes the captures (.wav) and extracted files (.prg, .gfx,
+// .bin, with optional .info headers) found under root.
+func BuildCatalog(root string, previewLines int) (*Catalog, error) {
+	c := &Catalog{}
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		source, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		source = filepath.ToSlash(source)
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".wav":
+			err = c.addCapture(source, path, previewLines)
+		case ".prg", ".gfx", ".bin":
+			err = c.addExtractedFile(source, path, previewLines)
+		}
+		if err != nil {
+			c.Errors = append(c.Errors, source+": "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entriesByHash := make(map[string][]int)
+	for i, entry := range c.Entries {
+		// files which could not be read have no contents to compare
+		if entry.SHA256 != "" {
+			entriesByHash[entry.SHA256] = append(entriesByHash[entry.SHA256], i)
+		}
+	}
+	for _, indices := range entriesByHash {
+		for _, i := range indices {
+			for _, j := range indices {
+				if i != j {
+					c.Entries[i].Duplicates = append(c.Entries[i].Duplicates, c.Entries[j].Location())
+				}
+			}
+			sort.Strings(c.Entries[i].Duplicates)
+		}
+	}
+
+	return c, nil
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"deref": func(b *bool) bool { return *b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Tape catalog</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #999; padding: 2px 6px; vertical-align: top; text-align: left; }
+.bad { color: #c00; }
+pre { margin: 0; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>Source</th><th>#</th><th>Name</th><th>Type</th><th>Size</th><th>SHA-256</th><th>Checksum</th><th>Duplicates</th><th>Preview</th></tr>
+{{range .Entries}}<tr>
+<td>{{.Source}}</td><td>{{.Index}}</td><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Size}}</td><td><code>{{printf "%.16s" .SHA256}}</code></td>
+<td>{{if .ChecksumOK}}{{if deref .ChecksumOK}}OK{{else}}<span class="bad">invalid</span>{{end}}{{end}}</td>
+<td>{{range .Duplicates}}{{.}}<br>{{end}}</td>
+<td>{{if .Preview}}<details><summary>listing</summary><pre>{{.Preview}}</pre></details>{{end}}{{if .Error}}<span class="bad">{{.Error}}</span>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Errors}}<h2>Errors</h2>
+<ul>{{range .Errors}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+func (c *Catalog) WriteHTML(w io.Writer) error {
+	return catalogTemplate.Execute(w, c)
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildCatalog(t *testing.T) {
+	info := FBFileInfo{Type: FileTypeBasic, Length: uint16(len(enriExampleBin)), LoadAddress: 0x6006, ExecutionAddress: 0x2020}
+	info.SetName("ENRI")
+	good := writeTestTape(t, []FBFile{{Info: info, Data: enriExampleBin}})
+
+	// flip a sample inside the data block, leaving it readable but with a
+	// wrong checksum
+	damaged, _ := writeMarkedTestTape(t, "ONE")
+	_, locations := readTestTapeFiles(t, damaged)
+	data := wavChunk(t, damaged, "data")
+	data[locations[0].End-1000] = byte(256 - int(data[locations[0].End-1000]))
+
+	// silence part of the header block of ONE, which play skips past
+	skipped, markers := writeMarkedTestTape(t, "ONE", "TWO")
+	data = wavChunk(t, skipped, "data")
+	for i := int(markers[1].Position) - 20000; i < int(markers[1].Position)-19400; i++ {
+		data[i] = 0x80
+	}
+
+	root := t.TempDir()
+	for name, data := range map[string][]byte{
+		"good.wav":      good,
+		"damaged.wav":   damaged,
+		"skipped.wav":   skipped,
+		"ENRI.prg":      enriExampleBin,
+		"copy/ENRI.prg": enriExampleBin,
+		"corrupt.prg":   {0x02, 0x0A, 0x00, 0x00},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	catalog, err := BuildCatalog(root, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(catalog.Errors) != 0 {
+		t.Errorf("unexpected errors: %v", catalog.Errors)
+	}
+	entries := make(map[string]CatalogEntry)
+	for _, entry := range catalog.Entries {
+		entries[entry.Location()] = entry
+	}
+	if len(entries) != 7 {
+		t.Fatalf("expected 7 entries, found %+v", catalog.Entries)
+	}
+
+	if entry := entries["good.wav#0"]; entry.ChecksumOK == nil || !*entry.ChecksumOK {
+		t.Errorf("expected a valid checksum: %+v", entry)
+	}
+	if entry := entries["damaged.wav#0"]; entry.ChecksumOK == nil || *entry.ChecksumOK {
+		t.Errorf("expected an invalid checksum: %+v", entry)
+	}
+	if entry := entries["ENRI.prg"]; entry.ChecksumOK != nil {
+		t.Errorf("expected no checksum status for an extracted file: %+v", entry)
+	}
+
+	for _, location := range []string{"copy/ENRI.prg", "good.wav#0", "skipped.wav#1"} {
+		if len(entries[location].Duplicates) != 3 {
+			t.Errorf("%s: expected 3 duplicates, found %v", location, entries[location].Duplicates)
+		}
+	}
+	if len(entries["damaged.wav#0"].Duplicates) != 0 {
+		t.Errorf("damaged file listed as a duplicate of %v", entries["damaged.wav#0"].Duplicates)
+	}
+	if duplicates := entries["ENRI.prg"].Duplicates; len(duplicates) != 3 || duplicates[0] != "copy/ENRI.prg" || duplicates[1] != "good.wav#0" || duplicates[2] != "skipped.wav#1" {
+		t.Errorf("unexpected duplicates %v", duplicates)
+	}
+	if entry := entries["ENRI.prg"]; entry.Preview != "10 FOR I=0 TO 10\n20 PRINT \"TEST \";\n" {
+		t.Errorf("unexpected preview %q", entry.Preview)
+	}
+
+	if entry := entries["skipped.wav#0"]; entry.Name != "ONE" || entry.Error == "" || entry.SHA256 != "" || len(entry.Duplicates) != 0 {
+		t.Errorf("expected an error for the damaged file: %+v", entry)
+	}
+	if entry := entries["skipped.wav#1"]; entry.Name != "TWO" || entry.Error != "" || entry.ChecksumOK == nil || !*entry.ChecksumOK {
+		t.Errorf("expected the file after the damaged one: %+v", entry)
+	}
+
+	if entry := entries["corrupt.prg"]; entry.Error == "" || entry.Preview != "" {
+		t.Errorf("expected an error for the corrupt program: %+v", entry)
+	}
+}
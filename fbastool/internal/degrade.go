@@ -0,0 +1,245 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// DegradeOptions configures the effects applied by DegradeTape. Levels are
+// relative to full scale; a zero value disables the effect.
+type DegradeOptions struct {
+	WhiteNoise    float64 // standard deviation of white noise
+	Hum           float64 // amplitude of mains hum
+	HumFrequency  float64 // mains frequency, in Hz (default 50)
+	DCWander      float64 // peak of a slowly wandering DC offset
+	Wow           float64 // depth of slow speed variation (0.01 = 1%)
+	Flutter       float64 // depth of fast speed variation
+	Dropouts      float64 // average number of dropouts per second
+	DropoutDepth  float64 // attenuation during a dropout, from 0 to 1 (default 0.9)
+	Clipping      float64 // level above which the signal is clipped
+	LowPass       float64 // cutoff frequency of head wear, in Hz
+	PolarityFlips float64 // average number of polarity flips per second
+	Invert        bool    // start with inverted polarity
+}
+
+// DegradeSeverity returns a mix of all effects, scaled by a severity from
+// 0 (clean) to 1 (barely readable).
+func DegradeSeverity(severity float64) DegradeOptions {
+	if severity <= 0 {
+		return DegradeOptions{}
+	}
+	options := DegradeOptions{
+		WhiteNoise:    0.08 * severity,
+		Hum:           0.1 * severity,
+		DCWander:      0.15 * severity,
+		Wow:           0.02 * severity,
+		Flutter:       0.005 * severity,
+		Dropouts:      0.5 * severity,
+		DropoutDepth:  0.5 + 0.5*severity,
+		LowPass:       12000 - 9000*severity,
+		PolarityFlips: 0.2 * severity,
+	}
+	options.Clipping = 0.25 - 0.2*severity
+	return options
+}
+
+type degradeState struct {
+	options    DegradeOptions
+	rng        *rand.Rand
+	sampleRate float64
+}
+
+// resample applies wow and flutter by reading the input at a varying speed.
+func (d *degradeState) resample(input []float64) []float64 {
+	if d.options.Wow == 0 && d.options.Flutter == 0 {
+		return input
+	}
+	wowPhase := d.rng.Float64() * 2 * math.Pi
+	flutterPhase := d.rng.Float64() * 2 * math.Pi
+	output := make([]float64, 0, len(input))
+	pos := 0.0
+	for i := 0; int(pos)+1 < len(input); i++ {
+		t := float64(i) / d.sampleRate
+		idx := int(pos)
+		frac := pos - float64(idx)
+		output = append(output, input[idx]*(1-frac)+input[idx+1]*frac)
+		pos += 1 + d.options.Wow*math.Sin(2*math.Pi*0.5*t+wowPhase) + d.options.Flutter*math.Sin(2*math.Pi*12*t+flutterPhase)
+	}
+	return output
+}
+
+func (d *degradeState) lowPass(samples []float64) {
+	if d.options.LowPass <= 0 {
+		return
+	}
+	a := 1 - math.Exp(-2*math.Pi*d.options.LowPass/d.sampleRate)
+	y := 0.0
+	for i, x := range samples {
+		y += a * (x - y)
+		samples[i] = y
+	}
+}
+
+func (d *degradeState) dropouts(samples []float64) {
+	if d.options.Dropouts <= 0 {
+		return
+	}
+	depth := d.options.DropoutDepth
+	if depth == 0 {
+		depth = 0.9
+	}
+	chance := d.options.Dropouts / d.sampleRate
+	remaining := 0
+	for i := range samples {
+		if remaining == 0 && d.rng.Float64() < chance {
+			// 5 to 50 milliseconds
+			remaining = int((0.005 + d.rng.Float64()*0.045) * d.sampleRate)
+		}
+		if remaining > 0 {
+			samples[i] *= 1 - depth
+			remaining--
+		}
+	}
+}
+
+func (d *degradeState) polarity(samples []float64) {
+	inverted := d.options.Invert
+	chance := d.options.PolarityFlips / d.sampleRate
+	for i := range samples {
+		if chance > 0 && d.rng.Float64() < chance {
+			inverted = !inverted
+		}
+		if inverted {
+			samples[i] = -samples[i]
+		}
+	}
+}
+
+func (d *degradeState) additive(samples []float64) {
+	humFrequency := d.options.HumFrequency
+	if humFrequency == 0 {
+		humFrequency = 50
+	}
+	dcFreq1 := 0.05 + d.rng.Float64()*0.2
+	dcFreq2 := 0.3 + d.rng.Float64()*0.7
+	dcPhase1 := d.rng.Float64() * 2 * math.Pi
+	dcPhase2 := d.rng.Float64() * 2 * math.Pi
+	for i := range samples {
+		t := float64(i) / d.sampleRate
+		if d.options.Hum != 0 {
+			samples[i] += d.options.Hum * (math.Sin(2*math.Pi*humFrequency*t) + 0.3*math.Sin(2*math.Pi*3*humFrequency*t)) / 1.3
+		}
+		if d.options.DCWander != 0 {
+			samples[i] += d.options.DCWander * (0.6*math.Sin(2*math.Pi*dcFreq1*t+dcPhase1) + 0.4*math.Sin(2*math.Pi*dcFreq2*t+dcPhase2))
+		}
+		if d.options.WhiteNoise != 0 {
+			samples[i] += d.rng.NormFloat64() * d.options.WhiteNoise
+		}
+	}
+}
+
+func (d *degradeState) clip(samples []float64) {
+	if d.options.Clipping <= 0 {
+		return
+	}
+	for i, v := range samples {
+		if v > d.options.Clipping {
+			samples[i] = d.options.Clipping
+		} else if v < -d.options.Clipping {
+			samples[i] = -d.options.Clipping
+		}
+	}
+}
+
+func (d *degradeState) apply(samples []float64) []float64 {
+	samples = d.resample(samples)
+	d.lowPass(samples)
+	d.dropouts(samples)
+	d.polarity(samples)
+	d.additive(samples)
+	d.clip(samples)
+	return samples
+}
+
+// DegradeTape copies the WAV file in src to dst, applying the given effects.
+// The output is reproducible for a given seed.
+func DegradeTape(src io.ReadSeeker, dst io.WriteSeeker, options DegradeOptions, seed int64) error {
+	decoder := wav.NewDecoder(src)
+	decoder.ReadInfo()
+	if decoder.Err() != nil || decoder.NumChans < 1 {
+		return errors.New("could not read wave file")
+	}
+	buffer, err := decoder.FullPCMBuffer()
+	if err != nil {
+		return err
+	}
+
+	bitDepth := int(decoder.BitDepth)
+	channels := int(decoder.NumChans)
+	offset, fullScale := 0, 1<<(bitDepth-1)
+	if bitDepth == 8 {
+		offset = 128
+	}
+
+	frames := len(buffer.Data) / channels
+	var output [][]float64
+	for c := 0; c < channels; c++ {
+		samples := make([]float64, frames)
+		for i := range samples {
+			samples[i] = float64(buffer.Data[i*channels+c]-offset) / float64(fullScale)
+		}
+		// every channel sees the same tape
+		state := degradeState{
+			options:    options,
+			rng:        rand.New(rand.NewSource(seed)),
+			sampleRate: float64(decoder.SampleRate),
+		}
+		output = append(output, state.apply(samples))
+	}
+
+	frames = len(output[0])
+	data := make([]int, frames*channels)
+	for c := 0; c < channels; c++ {
+		for i := 0; i < frames; i++ {
+			v := int(math.Round(output[c][i] * float64(fullScale)))
+			if v < -fullScale {
+				v = -fullScale
+			} else if v >= fullScale {
+				v = fullScale - 1
+			}
+			data[i*channels+c] = v + offset
+		}
+	}
+
+	encoder := wav.NewEncoder(dst, int(decoder.SampleRate), bitDepth, channels, int(decoder.WavAudioFormat))
+	err = encoder.Write(&audio.IntBuffer{Format: decoder.Format(), Data: data, SourceBitDepth: bitDepth})
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDegradeTape(t *testing.T) {
+	info := FBFileInfo{Type: FileTypeBasic, Length: uint16(len(enriExampleBin)), LoadAddress: 0x6006, ExecutionAddress: 0x2020}
+	info.SetName("ENRI")
+	files := []FBFile{{Info: info, Data: enriExampleBin}}
+	tape := writeTestTape(t, files)
+
+	var outputs [2]memoryFile
+	for i := range outputs {
+		err := DegradeTape(bytes.NewReader(tape), &outputs[i], DegradeSeverity(0.5), 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(outputs[0].Bytes(), outputs[1].Bytes()) {
+		t.Error("output differs between runs with the same seed")
+	}
+
+	for _, options := range []DegradeOptions{{Hum: 0.05}, {LowPass: 8000}, {Wow: 0.01}} {
+		err := VerifyTape(bytes.NewReader(tape), files, options, 1)
+		if err != nil {
+			t.Errorf("%+v: %v", options, err)
+		}
+	}
+}
@@ -21,12 +21,8 @@
 package internal
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
-	"strconv"
 	"strings"
 )
 
@@ -159,262 +155,32 @@ func hexToStringSpaces(buf []byte) string {
 	return s.String()
 }
 
-func FBBasicBinToString(reader io.Reader) (string, error) {
-	var s strings.Builder
-	endOfProgram := false
-
-	for !endOfProgram {
-		var nextOffset byte
-		binary.Read(reader, binary.LittleEndian, &nextOffset)
-		if nextOffset == 0 {
-			break
-		}
-
-		lineLength := int(nextOffset) - 3
-		var lineNumber uint16
-		binary.Read(reader, binary.LittleEndian, &lineNumber)
-		s.WriteString(strconv.Itoa(int(lineNumber)))
-		s.WriteString(" ")
-
-		lineData := make([]byte, lineLength)
-		reader.Read(lineData)
-		parsingComment := false
-		parsingString := false
-		nextNumberNegative := 0
-
-		for i := 0; i < len(lineData); i++ {
-			nextNumberNegative -= 1
-			id := lineData[i]
-			if id == 0x00 {
-				// end of line
-				break
-			} else if parsingComment {
-				s.WriteString(FBByteToString(id))
-			} else if parsingString {
-				s.WriteString(FBByteToString(id))
-				if id == '"' {
-					parsingString = false
-				}
-			} else if id >= 0x80 {
-				keyword, ok := idToKeywordMap[id]
-				if !ok {
-					fmt.Fprintf(os.Stderr, "skipping line %d, unknown token 0x%02X (%s)\n", lineNumber, id, hexToStringSpaces(lineData[i:]))
-					break
-				}
-				s.WriteString(keyword)
-				if id == 0x95 {
-					// REM acts as comment
-					parsingComment = true
-				}
-			} else if id == '\'' {
-				parsingComment = true
-				s.WriteString("'")
-			} else if id == '"' {
-				parsingString = true
-				s.WriteString("\"")
-			} else if id >= 0x20 && id <= 0x5B {
-				s.WriteString(FBByteToString(id))
-			} else if id == 0x12 {
-				// constant number
-				i++
-				v := int(lineData[i])
-				i++
-				v = v | (int(lineData[i]) << 8)
-				if nextNumberNegative == 1 {
-					v = -v
-				}
-				s.WriteString(strconv.Itoa(v))
-			} else if id == 0x11 {
-				// hex number
-				i++
-				v := int(lineData[i])
-				i++
-				v = v | (int(lineData[i]) << 8)
-				s.WriteString(fmt.Sprintf("&H%X", v))
-			} else if id == 0x0B {
-				// line number
-				i++
-				v := int(lineData[i])
-				i++
-				v = v | (int(lineData[i]) << 8)
-				s.WriteString(strconv.Itoa(v))
-			} else if id == 0xFA {
-				nextNumberNegative = 2
-			} else if id >= 0x01 && id <= 0x0A {
-				// constant number (short)
-				v := int(id) - 1
-				if nextNumberNegative == 1 {
-					v = -v
-				}
-				s.WriteString(strconv.Itoa(v))
-			} else {
-				fmt.Fprintf(os.Stderr, "skipping line %d, unknown token 0x%02X (%v)\n", lineNumber, id, hexToStringSpaces(lineData[i:]))
-				break
-			}
-		}
-		s.WriteString("\n")
+func FBBasicBinToString(reader io.Reader, dialect FBDialect) (string, error) {
+	program, err := FBReadProgram(reader, dialect)
+	if err != nil {
+		return "", err
 	}
-
-	return s.String(), nil
+	return program.String(), nil
 }
 
-func FBBasicStringToBin(s string, writer io.Writer) error {
-	for _, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
-		var lineBuf bytes.Buffer
-		lineArray := strings.SplitN(line, " ", 2)
-		if len(lineArray) != 2 {
-			continue
-		}
-		lineNumber, err := strconv.Atoi(lineArray[0])
-		if err != nil {
-			continue
-		}
-		s := lineArray[1]
-		readingLineNumbers := false
-		currAlpha := false
-		lastAlpha := false
-		lastLen := 0
-		currLen := 0
-		for len(s) > 0 {
-			prefixByte := byte(0)
-			prefixLen := 0
-			for k, v := range idToKeywordMap {
-				if len(v) > prefixLen && strings.HasPrefix(s, v) {
-					prefixByte = k
-					prefixLen = len(v)
-				}
-			}
-			lastLen = currLen
-			currLen = len(s)
-			if lastLen == currLen {
-				return fmt.Errorf("cannot parse line %d beyond '%s' (stuck)", lineNumber, s)
-			}
-			lastAlpha = currAlpha
-			currAlpha = false
-			if s[0] == 0x20 {
-				// skip spaces early (so "GOTO"[space]"line number") works
-				lineBuf.WriteByte(0x20)
-				s = s[1:]
-				continue
-			}
-			if prefixLen > 0 {
-				lineBuf.WriteByte(prefixByte)
-				currKeyword := s[:prefixLen]
-				s = s[prefixLen:]
-				if currKeyword == "REM" || currKeyword == "DATA" {
-					// comment
-					sParsed, err := FBStringToBytes(s)
-					if err != nil {
-						return err
-					}
-					lineBuf.Write(sParsed)
-					break
-				}
-				readingLineNumbers = (currKeyword == "GOSUB" || currKeyword == "GOTO" || currKeyword == "RETURN" || currKeyword == "RESTORE" || currKeyword == "RUN" || currKeyword == "THEN")
-			} else if s[0] == '\'' {
-				// comment
-				sParsed, err := FBStringToBytes(s)
-				if err != nil {
-					return err
-				}
-				lineBuf.Write(sParsed)
-				break
-			} else if s[0] == '"' {
-				// string
-				lineBuf.WriteByte('"')
-				s = s[1:]
-				splitPos := strings.Index(s, "\"")
-				if splitPos < 0 {
-					sParsed, err := FBStringToBytes(s)
-					if err != nil {
-						return err
-					}
-					lineBuf.Write(sParsed)
-					break
-				} else {
-					sParsed, err := FBStringToBytes(s[0 : splitPos+1])
-					if err != nil {
-						return err
-					}
-					lineBuf.Write(sParsed)
-				}
-				s = s[splitPos+1:]
-			} else if !lastAlpha && (s[0] >= '0' && s[0] <= '9' || (len(s) >= 2 && s[0] == '-' && s[1] >= '0' && s[1] <= '9')) {
-				// digits
-				x := 0
-				if s[0] == '-' {
-					// negative value marker
-					lineBuf.WriteByte(0xFA)
-					x++
-				}
-				xStart := x
-				for x < len(s) && s[x] >= '0' && s[x] <= '9' {
-					x++
-				}
-				v, err := strconv.Atoi(s[xStart:x])
-				if err != nil {
-					return err
-				}
-				if v >= 65536 {
-					return fmt.Errorf("value out of range: %d", v)
-				}
-				if readingLineNumbers {
-					// line number
-					lineBuf.WriteByte(0x0B)
-					lineBuf.WriteByte(byte(v & 0xFF))
-					lineBuf.WriteByte(byte((v >> 8) & 0xFF))
-				} else if v >= 10 {
-					// long constant number
-					lineBuf.WriteByte(0x12)
-					lineBuf.WriteByte(byte(v & 0xFF))
-					lineBuf.WriteByte(byte((v >> 8) & 0xFF))
-				} else {
-					// short constant number
-					lineBuf.WriteByte(byte(v + 1))
-				}
-				s = s[x:]
-			} else if len(s) >= 2 && s[0] == '&' && s[1] == 'H' {
-				// hex number
-				x := 2
-				xStart := x
-				for x < len(s) && ((s[x] >= '0' && s[x] <= '9') || (s[x] >= 'A' && s[x] <= 'F')) {
-					x++
-				}
-				v, err := strconv.ParseInt(s[xStart:x], 16, 32)
-				if err != nil {
-					return err
-				}
-				if v >= 65536 {
-					return fmt.Errorf("value out of range: %d", v)
-				}
-				lineBuf.WriteByte(0x11)
-				lineBuf.WriteByte(byte(v & 0xFF))
-				lineBuf.WriteByte(byte((v >> 8) & 0xFF))
-				s = s[x:]
-			} else if s[0] >= 0x21 && s[0] <= 0x5B {
-				// other character
-				lineBuf.WriteByte(s[0])
-				if s[0] != ',' {
-					readingLineNumbers = false
-				}
-				if s[0] >= 0x41 && s[0] <= 0x5B {
-					currAlpha = true
-				}
-				s = s[1:]
-			} else {
-				return fmt.Errorf("cannot parse line %d beyond '%s'", lineNumber, s)
-			}
-		}
-		lineBuf.WriteByte(0x00)
+// FBBasicStringToBin tokenizes a program listing. Every line is checked,
+// and all problems found are returned as diagnostics; the program is only
+// written if none of them are errors.
+func FBBasicStringToBin(s string, dialect FBDialect, writer io.Writer) ([]FBDiagnostic, error) {
+	return FBBasicStringToBinWithOptions(s, dialect, FBParseOptions{}, writer)
+}
 
-		lineData := lineBuf.Bytes()
-		if len(lineData) >= 253 {
-			return fmt.Errorf("line too long")
-		}
-		writer.Write([]byte{byte(len(lineData) + 3), byte(lineNumber & 0xFF), byte((lineNumber >> 8) & 0xFF)})
-		writer.Write(lineData)
+// FBBasicStringToBinWithOptions tokenizes a program listing like
+// FBBasicStringToBin, as changed by the options.
+func FBBasicStringToBinWithOptions(s string, dialect FBDialect, opts FBParseOptions, writer io.Writer) ([]FBDiagnostic, error) {
+	program, diags := FBParseProgramWithOptions(s, dialect, opts)
+	if errorCount := FBDiagnosticErrorCount(diags); errorCount > 0 {
+		return diags, fmt.Errorf("%d errors found", errorCount)
 	}
-	writer.Write([]byte{0x00})
-
-	return nil
+	data, err := program.MarshalBinary()
+	if err != nil {
+		return diags, err
+	}
+	_, err = writer.Write(data)
+	return diags, err
 }
@@ -22,6 +22,11 @@ package internal
 
 import (
 	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -49,7 +54,7 @@ var enriExampleBin = []byte{
 }
 
 func TestEnriString(t *testing.T) {
-	enriGeneratedText, err := FBBasicBinToString(bytes.NewReader(enriExampleBin))
+	enriGeneratedText, err := FBBasicBinToString(bytes.NewReader(enriExampleBin), DefaultFBDialect)
 	if err != nil {
 		t.Error(err)
 	}
@@ -57,3 +62,269 @@ func TestEnriString(t *testing.T) {
 		t.Errorf("mismatch\nexpected:\n%s\n\nactual:\n%s", enriExampleText, enriGeneratedText)
 	}
 }
+
+func TestStringToBinDiagnostics(t *testing.T) {
+	text := "10 PRINT 1\nPRINT 2\n20 PRINT \"ok\";ABC;abc\n30 A=99999\n40\n\f\n\v\n\u00a0\n"
+	expected := []FBDiagnostic{
+		{Line: 2, Column: 1, Text: "PRINT", Severity: SeverityError, Kind: DiagMissingLineNumber},
+		{Line: 3, Column: 11, Text: "ok", Severity: SeverityError, Kind: DiagInvalidCharacter},
+		{Line: 3, Column: 19, Text: "abc", Severity: SeverityError, Kind: DiagInvalidCharacter},
+		{Line: 4, Column: 6, Text: "99999", Severity: SeverityError, Kind: DiagValueOutOfRange},
+		{Line: 5, Column: 1, Text: "40", Severity: SeverityWarning, Kind: DiagEmptyLine},
+	}
+
+	var buf bytes.Buffer
+	diags, err := FBBasicStringToBin(text, DefaultFBDialect, &buf)
+	if err == nil {
+		t.Error("expected an error")
+	}
+	if buf.Len() != 0 {
+		t.Error("program written despite errors")
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, d := range diags {
+		d.Message = ""
+		if d != expected[i] {
+			t.Errorf("diagnostic %d: expected %+v, got %+v", i, expected[i], d)
+		}
+	}
+}
+
+func TestReadCorruptProgram(t *testing.T) {
+	for _, data := range [][]byte{
+		{0x02, 0x0A, 0x00, 0x00},
+		{0x08, 0x0A},
+		enriExampleBin[:len(enriExampleBin)-3],
+	} {
+		if _, err := FBReadProgram(bytes.NewReader(data), DefaultFBDialect); err == nil {
+			t.Errorf("% X: expected an error", data)
+		}
+	}
+}
+
+func TestProgramTokens(t *testing.T) {
+	program, err := FBReadProgram(bytes.NewReader(enriExampleBin), DefaultFBDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []FBTokenKind{TokenKeyword, TokenRaw, TokenRaw, TokenKeyword, TokenDecimal, TokenRaw, TokenKeyword, TokenRaw, TokenDecimal}
+	if len(program.Lines) != 3 || len(program.Lines[0].Tokens) != len(kinds) {
+		t.Fatalf("unexpected program structure: %+v", program.Lines)
+	}
+	for i, token := range program.Lines[0].Tokens {
+		if token.Kind != kinds[i] {
+			t.Errorf("token %d: expected %v, got %v", i, kinds[i], token.Kind)
+		}
+	}
+	if v := program.Lines[0].Tokens[8].Value(); v != 10 {
+		t.Errorf("expected value 10, got %d", v)
+	}
+	if token := program.Lines[1].Tokens[2]; token.Kind != TokenString || token.String() != "\"TEST \"" {
+		t.Errorf("unexpected string token %+v", token)
+	}
+
+	data, err := program.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the program is terminated by an empty line
+	expected := append(append([]byte{}, enriExampleBin...), 0x00)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("mismatch\nexpected: %s\nactual:   %s", hexToStringSpaces(expected), hexToStringSpaces(data))
+	}
+}
+
+func TestParseProgramTokens(t *testing.T) {
+	program, diags := FBParseProgram("10 GOTO 20:DATA 1,\"A:B\"\n20 ' END\n", DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	line := program.Lines[0]
+	if line.Tokens[2].Kind != TokenLineNumber || line.Tokens[2].Value() != 20 || line.Tokens[2].Column != 9 {
+		t.Errorf("unexpected line number token %+v", line.Tokens[2])
+	}
+	if !line.Tokens[4].IsKeyword("DATA") {
+		t.Errorf("expected DATA, got %+v", line.Tokens[4])
+	}
+	if comment := program.Lines[1].Tokens[0]; comment.Kind != TokenComment || comment.String() != "' END" {
+		t.Errorf("unexpected comment token %+v", comment)
+	}
+}
+
+func TestParseSourceComments(t *testing.T) {
+	text := "// ball\n\n  10 CLS   // clear\n20 PRINT \"A//B\":DATA 1,2 // data\n30 X=1 //! set x\n// end\n"
+	program, diags := FBParseProgram(text, DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if line := program.Lines[1].PlainString(); line != "20 PRINT \"A//B\":DATA 1,2" {
+		t.Errorf("unexpected line %s", line)
+	}
+	expected := "// ball\n10 CLS // clear\n20 PRINT \"A//B\":DATA 1,2 // data\n30 X=1 //! set x\n// end\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+
+	program, _ = FBParseProgramWithOptions(text, DefaultFBDialect, FBParseOptions{KeepComments: true})
+	if line := program.Lines[2].PlainString(); line != "30 X=1:REM SET X" {
+		t.Errorf("unexpected line %s", line)
+	}
+
+	// // is division twice in a tokenized program
+	data := []byte{0x08, 0x0A, 0x00, 'A', 0xFC, 0xFC, 0x02, 0x00, 0x00}
+	text, err := FBBasicBinToString(bytes.NewReader(data), DefaultFBDialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := FBBasicStringToBin(text, DefaultFBDialect, &buf); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("%q does not encode back: %s", text, hexToStringSpaces(buf.Bytes()))
+	}
+}
+
+func TestRoundTripCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/roundtrip/*.prg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, err := FBBasicBinToString(bytes.NewReader(data), DefaultFBDialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		diags, err := FBBasicStringToBin(text, DefaultFBDialect, &buf)
+		if err != nil {
+			t.Errorf("%s: %v %v\n%s", file, err, diags, text)
+		} else if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%s: mismatch\nexpected: %s\nactual:   %s\n%s", file, hexToStringSpaces(data), hexToStringSpaces(buf.Bytes()), text)
+		}
+	}
+}
+
+func TestRoundTripRandom(t *testing.T) {
+	var keywords []byte
+	for k := range idToKeywordMap {
+		if k != 0x95 {
+			keywords = append(keywords, k)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool { return keywords[i] < keywords[j] })
+
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		line := FBLine{Number: rng.Intn(65536)}
+		for j := rng.Intn(12); j > 0; j-- {
+			switch rng.Intn(7) {
+			case 0:
+				line.Tokens = append(line.Tokens, FBToken{Kind: TokenKeyword, Bytes: []byte{keywords[rng.Intn(len(keywords))]}})
+			case 1:
+				kind := []FBTokenKind{TokenLineNumber, TokenDecimal, TokenHex}[rng.Intn(3)]
+				line.Tokens = append(line.Tokens, NewFBNumberToken(kind, rng.Intn(65536)))
+			case 2:
+				line.Tokens = append(line.Tokens, NewFBNumberToken(TokenShort, rng.Intn(10)))
+			case 3:
+				data := []byte{'"'}
+				for k := rng.Intn(5); k > 0; k-- {
+					if c := byte(rng.Intn(255) + 1); c != '"' {
+						data = append(data, c)
+					}
+				}
+				line.Tokens = append(line.Tokens, FBToken{Kind: TokenString, Bytes: append(data, '"')})
+			case 4:
+				if c := byte(rng.Intn(256)); fbByteTokenKind(c, DefaultFBDialect) == TokenUnknown {
+					line.Tokens = append(line.Tokens, FBToken{Kind: TokenUnknown, Bytes: []byte{c}})
+				}
+			default:
+				// quotes always start a string or comment
+				if c := byte(rng.Intn(0x5B-0x20) + 0x20); c != '"' && c != '\'' {
+					line.Tokens = append(line.Tokens, FBToken{Kind: TokenRaw, Bytes: []byte{c}})
+				}
+			}
+		}
+		if rng.Intn(4) == 0 {
+			line.Tokens = append(line.Tokens, FBToken{Kind: TokenComment, Bytes: []byte{0x95, 'A', 0xE0, ':'}})
+		}
+
+		expected := line.Bytes()
+		program, err := FBReadProgram(bytes.NewReader(append(append([]byte{byte(len(expected) + 3), byte(line.Number), byte(line.Number >> 8)}, expected...), 0)), DefaultFBDialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := program.Lines[0].String()
+		parsed, diags := FBParseProgram(text, DefaultFBDialect)
+		if FBDiagnosticErrorCount(diags) > 0 || len(parsed.Lines) != 1 {
+			t.Fatalf("%q: %v", text, diags)
+		}
+		if actual := parsed.Lines[0].Bytes(); !bytes.Equal(actual, expected) || parsed.Lines[0].Number != line.Number {
+			t.Fatalf("%q: mismatch\nexpected: %s\nactual:   %s", text, hexToStringSpaces(expected), hexToStringSpaces(actual))
+		}
+	}
+}
+
+func TestDialectKeywords(t *testing.T) {
+	v3, _ := FBParseProgram("10 ERROR 20", DialectV3)
+	if !v3.Lines[0].Tokens[0].IsKeyword("ERROR") {
+		t.Errorf("expected ERROR keyword in V3, got %+v", v3.Lines[0].Tokens)
+	}
+	v2, diags := FBParseProgram("10 ERROR 20", DialectV2_1)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	// read as the letters E, R, R followed by OR
+	if tokens := v2.Lines[0].Tokens; tokens[0].Kind != TokenRaw || !tokens[3].IsKeyword("OR") {
+		t.Errorf("expected letters in V2.1, got %+v", tokens)
+	}
+	if keywords := v3.UnsupportedKeywords(DialectV2_1); len(keywords) != 1 || keywords[0] != "ERROR" {
+		t.Errorf("unexpected unsupported keywords %v", keywords)
+	}
+
+	data, _ := v3.MarshalBinary()
+	text, _ := FBBasicBinToString(bytes.NewReader(data), DialectV2_1)
+	if strings.TrimSpace(text) != "10 {$BC} 20" {
+		t.Errorf("unexpected V2.1 text %q", text)
+	}
+	if _, diags := FBParseProgram("10 {ERROR}", DialectV2_1); len(diags) != 1 || diags[0].Kind != DiagUnsupportedKeyword {
+		t.Errorf("expected an unsupported keyword diagnostic, got %v", diags)
+	}
+}
+
+func TestCrunchCorpus(t *testing.T) {
+	data, err := os.ReadFile("testdata/crunch.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		} else if !strings.HasPrefix(line, "= ") {
+			text = line
+			continue
+		}
+
+		var expected []byte
+		for _, v := range strings.Fields(line[2:]) {
+			b, err := strconv.ParseUint(v, 16, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected = append(expected, byte(b))
+		}
+		program, diags := FBParseProgram(text, DefaultFBDialect)
+		if len(diags) != 0 || len(program.Lines) != 1 {
+			t.Errorf("%s: %v", text, diags)
+			continue
+		}
+		actual := program.Lines[0].Bytes()
+		if !bytes.Equal(actual[:len(actual)-1], expected) {
+			t.Errorf("%s\nexpected: %s\nactual:   %s", text, hexToStringSpaces(expected), hexToStringSpaces(actual[:len(actual)-1]))
+		}
+	}
+}
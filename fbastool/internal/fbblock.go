@@ -115,6 +115,7 @@ func (i *FBFileInfo) UnmarshalBinary(buf []byte) error {
 	i.Type = FBFileType(buf[0])
 
 	copy(i.Name[:], buf[1:17])
+	i.Reserved1 = buf[17]
 	i.Length = binary.LittleEndian.Uint16(buf[18:])
 	i.LoadAddress = binary.LittleEndian.Uint16(buf[20:])
 	i.ExecutionAddress = binary.LittleEndian.Uint16(buf[22:])
@@ -0,0 +1,199 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FBLineChecksum is an algorithm for the short per-line codes printed next
+// to type-in listings. It is computed over the line number, low byte
+// first, followed by the tokenized line without its terminating zero.
+type FBLineChecksum interface {
+	Name() string
+	Sum(data []byte) int
+	Format(sum int) string
+	// BasicUpdate returns a Family BASIC expression for the checksum S
+	// after adding the byte B, for the generated checker program.
+	BasicUpdate() string
+}
+
+// fbByteChecksum is computed byte by byte as (sum * multiplier + byte)
+// mod 256, which keeps every step within Family BASIC's 16-bit integers.
+type fbByteChecksum struct {
+	name       string
+	multiplier int
+}
+
+func (c fbByteChecksum) Name() string {
+	return c.name
+}
+
+func (c fbByteChecksum) Sum(data []byte) int {
+	sum := 0
+	for _, b := range data {
+		sum = (sum*c.multiplier + int(b)) % 256
+	}
+	return sum
+}
+
+func (c fbByteChecksum) Format(sum int) string {
+	return fmt.Sprintf("%02X", sum)
+}
+
+func (c fbByteChecksum) BasicUpdate() string {
+	if c.multiplier == 1 {
+		return "(S+B) MOD 256"
+	}
+	return fmt.Sprintf("(S*%d+B) MOD 256", c.multiplier)
+}
+
+// FBLineChecksums holds the available algorithms, by name.
+var FBLineChecksums = map[string]FBLineChecksum{
+	// a plain sum of the bytes
+	"sum": fbByteChecksum{name: "sum", multiplier: 1},
+	// also catches swapped bytes
+	"fb8": fbByteChecksum{name: "fb8", multiplier: 3},
+}
+
+const DefaultFBLineChecksum = "fb8"
+
+func FBLineChecksumByName(name string) (FBLineChecksum, error) {
+	if c, ok := FBLineChecksums[name]; ok {
+		return c, nil
+	}
+	names := make([]string, 0, len(FBLineChecksums))
+	for n := range FBLineChecksums {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown checksum %s (expected one of: %s)", name, strings.Join(names, ", "))
+}
+
+// ChecksumData returns the bytes a line checksum is computed over.
+func (l FBLine) ChecksumData() []byte {
+	data := l.Bytes()
+	return append([]byte{byte(l.Number & 0xFF), byte((l.Number >> 8) & 0xFF)}, data[:len(data)-1]...)
+}
+
+// Checksum returns the formatted code of the line.
+func (l FBLine) Checksum(c FBLineChecksum) string {
+	return c.Format(c.Sum(l.ChecksumData()))
+}
+
+// WriteChecksumListing writes the program as a listing with the code of
+// each line in front of it.
+func (p *FBProgram) WriteChecksumListing(w io.Writer, c FBLineChecksum) {
+	for _, l := range p.Lines {
+		fmt.Fprintf(w, "%s  %s\n", l.Checksum(c), l.Text(p.Dialect))
+	}
+}
+
+// WriteChecksums writes the code of each line, one "number code" pair per
+// line, as read by ReadFBChecksums.
+func (p *FBProgram) WriteChecksums(w io.Writer, c FBLineChecksum) {
+	for _, l := range p.Lines {
+		fmt.Fprintf(w, "%d %s\n", l.Number, l.Checksum(c))
+	}
+}
+
+// FBChecksum is the expected code of a line.
+type FBChecksum struct {
+	Number int
+	Code   string
+}
+
+// ReadFBChecksums reads expected codes, one "number code" pair per line.
+// Empty lines and lines starting with # are skipped.
+func ReadFBChecksums(r io.Reader) ([]FBChecksum, error) {
+	var sums []FBChecksum
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		fields := strings.Fields(strings.ReplaceAll(scanner.Text(), ":", " "))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		number, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected line number and code", i)
+		}
+		sums = append(sums, FBChecksum{Number: number, Code: strings.ToUpper(fields[1])})
+	}
+	return sums, scanner.Err()
+}
+
+// CheckChecksums compares the program against the expected codes,
+// returning a message for each line which is wrong, missing or not
+// expected.
+func (p *FBProgram) CheckChecksums(c FBLineChecksum, expected []FBChecksum) []string {
+	var problems []string
+	codes := make(map[int]string)
+	for _, e := range expected {
+		codes[e.Number] = e.Code
+	}
+	for _, l := range p.Lines {
+		code, ok := codes[l.Number]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("line %d: not in the listing", l.Number))
+		} else if actual := l.Checksum(c); actual != code {
+			problems = append(problems, fmt.Sprintf("line %d: code is %s, expected %s", l.Number, actual, code))
+		}
+		delete(codes, l.Number)
+	}
+	for _, e := range expected {
+		if _, ok := codes[e.Number]; ok {
+			problems = append(problems, fmt.Sprintf("line %d: missing", e.Number))
+		}
+	}
+	return problems
+}
+
+// fbProgramAddress is where programs start in memory, as in the tape
+// header written by record.
+const fbProgramAddress = 0x6006
+
+// FBChecksumChecker returns a Family BASIC program, numbered from start,
+// which prints the code of each line of the program typed in before it,
+// pausing for a key every 20 lines.
+func FBChecksumChecker(c FBLineChecksum, start int) (string, error) {
+	if start < 10 || start+60 > 32767 {
+		return "", fmt.Errorf("checker must start between 10 and %d", 32767-60)
+	}
+	lines := []string{
+		fmt.Sprintf("A=&H%X:C=0", fbProgramAddress),
+		fmt.Sprintf("L=PEEK(A):H=PEEK(A+2):IF L=0 OR H>%d OR (H=%d AND PEEK(A+1)>=%d) THEN END", start>>8, start>>8, start&0xFF),
+		fmt.Sprintf("S=0:FOR I=A+1 TO A+L-2:B=PEEK(I):S=%s:NEXT", c.BasicUpdate()),
+		"PRINT PEEK(A+1)+H*256;\" \";RIGHT$(\"0\"+HEX$(S),2)",
+		fmt.Sprintf("A=A+L:C=C+1:IF C MOD 20>0 THEN %d", start+10),
+		fmt.Sprintf("IF INKEY$=\"\" THEN %d", start+50),
+		fmt.Sprintf("GOTO %d", start+10),
+	}
+	var s strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&s, "%d %s\n", start+i*10, line)
+	}
+	return s.String(), nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineChecksums(t *testing.T) {
+	program, diags := FBParseProgram("10 PRINT 1\n20 GOTO 10\n", DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if data := program.Lines[0].ChecksumData(); !reflect.DeepEqual(data, []byte{0x0A, 0x00, 0x8B, 0x20, 0x02}) {
+		t.Fatalf("unexpected checksum data % X", data)
+	}
+	if code := program.Lines[0].Checksum(FBLineChecksums["sum"]); code != "B7" {
+		t.Errorf("sum: expected B7, got %s", code)
+	}
+	if code := program.Lines[0].Checksum(FBLineChecksums["fb8"]); code != "6F" {
+		t.Errorf("fb8: expected 6F, got %s", code)
+	}
+
+	sum := FBLineChecksums[DefaultFBLineChecksum]
+	var codes strings.Builder
+	program.WriteChecksums(&codes, sum)
+	expected, err := ReadFBChecksums(strings.NewReader("# codes\n" + codes.String() + "30 00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, _ := FBParseProgram("10 PRINT 2\n20 GOTO 10\n", DefaultFBDialect)
+	problems := typed.CheckChecksums(sum, expected)
+	if len(problems) != 2 || !strings.HasPrefix(problems[0], "line 10: code is") || problems[1] != "line 30: missing" {
+		t.Errorf("unexpected problems %q", problems)
+	}
+}
+
+func TestChecksumChecker(t *testing.T) {
+	for name, sum := range FBLineChecksums {
+		listing, err := FBChecksumChecker(sum, 32000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		program, diags := FBParseProgram("10 FOR I=0 TO 9:PRINT \"ABC\";I\n20 NEXT\n"+listing, DefaultFBDialect)
+		if len(diags) != 0 {
+			t.Fatal(diags)
+		}
+		if diags := program.Lint(FBMaxEditorLineLength); len(diags) != 0 {
+			t.Errorf("%s: checker has problems: %v", name, diags)
+		}
+
+		// walk the program in memory the way the checker does
+		memory, err := program.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines int
+		for a := 0; memory[a] != 0 && memory[a+2] < 32000>>8; a += int(memory[a]) {
+			l := program.Lines[lines]
+			if code := sum.Format(sum.Sum(memory[a+1 : a+int(memory[a])-1])); code != l.Checksum(sum) {
+				t.Errorf("%s: line %d: checker would show %s, expected %s", name, l.Number, code, l.Checksum(sum))
+			}
+			lines++
+		}
+		if lines != 2 {
+			t.Errorf("%s: checker would show %d lines", name, lines)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FBLineDiff is a line of two compared programs. A or B is nil if the
+// line is missing from that program.
+type FBLineDiff struct {
+	Number int
+	A, B   *FBLine
+	// ChangedA and ChangedB mark the tokens of A and B which differ.
+	ChangedA, ChangedB []bool
+}
+
+func (d FBLineDiff) Equal() bool {
+	if d.A == nil || d.B == nil {
+		return false
+	}
+	for _, c := range d.ChangedA {
+		if c {
+			return false
+		}
+	}
+	for _, c := range d.ChangedB {
+		if c {
+			return false
+		}
+	}
+	return true
+}
+
+func isFBSpace(t FBToken) bool {
+	return t.Kind == TokenRaw && t.Bytes[0] == ' '
+}
+
+// fbDiffTokens marks the tokens of a and b outside their longest common
+// subsequence. Spaces are not significant, and never marked.
+func fbDiffTokens(a, b []FBToken) ([]bool, []bool) {
+	var ai, bi []int
+	for i, t := range a {
+		if !isFBSpace(t) {
+			ai = append(ai, i)
+		}
+	}
+	for i, t := range b {
+		if !isFBSpace(t) {
+			bi = append(bi, i)
+		}
+	}
+
+	lcs := make([][]int, len(ai)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bi)+1)
+	}
+	for i := len(ai) - 1; i >= 0; i-- {
+		for j := len(bi) - 1; j >= 0; j-- {
+			if string(a[ai[i]].Bytes) == string(b[bi[j]].Bytes) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	changedA := make([]bool, len(a))
+	changedB := make([]bool, len(b))
+	i, j := 0, 0
+	for i < len(ai) || j < len(bi) {
+		if i < len(ai) && j < len(bi) && string(a[ai[i]].Bytes) == string(b[bi[j]].Bytes) {
+			i++
+			j++
+		} else if j >= len(bi) || (i < len(ai) && lcs[i+1][j] >= lcs[i][j+1]) {
+			changedA[ai[i]] = true
+			i++
+		} else {
+			changedB[bi[j]] = true
+			j++
+		}
+	}
+	return changedA, changedB
+}
+
+// FBCompare aligns the lines of two programs by number and compares them
+// token by token. The lines are taken in number order, whatever order they
+// were typed in; where a number appears more than once, the last line is
+// kept, as when typing it in.
+func FBCompare(a, b *FBProgram) []FBLineDiff {
+	a = &FBProgram{Lines: append([]FBLine(nil), a.Lines...)}
+	a.SortLines()
+	b = &FBProgram{Lines: append([]FBLine(nil), b.Lines...)}
+	b.SortLines()
+
+	var diffs []FBLineDiff
+	i, j := 0, 0
+	for i < len(a.Lines) || j < len(b.Lines) {
+		var d FBLineDiff
+		if j >= len(b.Lines) || (i < len(a.Lines) && a.Lines[i].Number < b.Lines[j].Number) {
+			d = FBLineDiff{Number: a.Lines[i].Number, A: &a.Lines[i]}
+			i++
+		} else if i >= len(a.Lines) || b.Lines[j].Number < a.Lines[i].Number {
+			d = FBLineDiff{Number: b.Lines[j].Number, B: &b.Lines[j]}
+			j++
+		} else {
+			d = FBLineDiff{Number: a.Lines[i].Number, A: &a.Lines[i], B: &b.Lines[j]}
+			d.ChangedA, d.ChangedB = fbDiffTokens(d.A.Tokens, d.B.Tokens)
+			i++
+			j++
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// fbMarkedText returns the line as text, with the marked tokens in
+// brackets.
+func fbMarkedText(l *FBLine, changed []bool) string {
+	if l == nil {
+		return ""
+	}
+	var s strings.Builder
+	s.WriteString(strconv.Itoa(l.Number))
+	s.WriteString(" ")
+	for i, t := range l.Tokens {
+		text, _ := t.annotated(0)
+		if changed != nil && changed[i] && (i == 0 || !changed[i-1]) {
+			s.WriteString("[")
+		}
+		s.WriteString(text)
+		if changed != nil && changed[i] && (i == len(l.Tokens)-1 || !changed[i+1]) {
+			s.WriteString("]")
+		}
+	}
+	return s.String()
+}
+
+// SideBySide returns both versions of the line as text, with the tokens
+// which differ in brackets.
+func (d FBLineDiff) SideBySide() (string, string) {
+	return fbMarkedText(d.A, d.ChangedA), fbMarkedText(d.B, d.ChangedB)
+}
+
+// FBUpperCase converts ASCII letters to upper case, as Family BASIC has
+// no lower case letters. Character escapes, such as \xB7, are kept as-is.
+func FBUpperCase(s string) string {
+	var result strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r >= 'a' && r <= 'z' && !escaped {
+			r = r - 'a' + 'A'
+		}
+		escaped = (r == '\\')
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// FBMergeListings merges two listings compared with FBCompare, taking each
+// differing line from a or b as choose returns "a" or "b", and marking a
+// conflict between the two otherwise. Program lines are written as in
+// upper case; everything else, such as directives, // comments and blank
+// lines, is taken from a, where it was. names label the conflicts. It
+// returns the merged listing and the number of conflicts.
+func FBMergeListings(a, b string, names [2]string, diffs []FBLineDiff, choose func(FBLineDiff) string) (string, int) {
+	var sources [2][]string
+	for i, text := range []string{a, b} {
+		sources[i] = strings.Split(strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n"), "\n")
+	}
+	programLines := make(map[int]bool)
+	for _, d := range diffs {
+		if d.A != nil {
+			programLines[d.A.Source] = true
+		}
+	}
+
+	var merged strings.Builder
+	next := 1 // the next line of a not yet written
+	writeOther := func(end int) {
+		for ; next < end && next <= len(sources[0]); next++ {
+			if !programLines[next] {
+				merged.WriteString(sources[0][next-1])
+				merged.WriteString("\n")
+			}
+		}
+	}
+	writeLine := func(source []string, l *FBLine) {
+		if l == nil {
+			return
+		}
+		text := source[l.Source-1]
+		code := text[:len(text)-len(l.Comment)]
+		merged.WriteString(FBUpperCase(code))
+		merged.WriteString(l.Comment)
+		merged.WriteString("\n")
+	}
+
+	conflicts := 0
+	for _, d := range diffs {
+		if d.A != nil {
+			writeOther(d.A.Source)
+			if next <= d.A.Source {
+				next = d.A.Source + 1
+			}
+		}
+		choice := "a"
+		if !d.Equal() {
+			choice = choose(d)
+		}
+		switch choice {
+		case "a":
+			writeLine(sources[0], d.A)
+		case "b":
+			writeLine(sources[1], d.B)
+		default:
+			conflicts++
+			merged.WriteString("<<<<<<< " + names[0] + "\n")
+			writeLine(sources[0], d.A)
+			merged.WriteString("=======\n")
+			writeLine(sources[1], d.B)
+			merged.WriteString(">>>>>>> " + names[1] + "\n")
+		}
+	}
+	writeOther(len(sources[0]) + 1)
+	return merged.String(), conflicts
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	a, _ := FBParseProgram(FBUpperCase("10 for i=0 to 9\n20 print \"HELLO\";i\n30 next\n50 B=2\n"), DefaultFBDialect)
+	b, _ := FBParseProgram("10 FOR I=0TO9\n20 PRINT \"HELLQ\";I\n25 X=1\n30 NEXT\n50 B=2:END\n", DefaultFBDialect)
+	expected := []struct {
+		number      int
+		equal       bool
+		left, right string
+	}{
+		{10, true, "10 FOR I=0 TO 9", "10 FOR I=0TO9"},
+		{20, false, "20 PRINT [\"HELLO\"];I", "20 PRINT [\"HELLQ\"];I"},
+		{25, false, "", "25 X=1"},
+		{30, true, "30 NEXT", "30 NEXT"},
+		{50, false, "50 B=2", "50 B=2[:END]"},
+	}
+
+	diffs := FBCompare(a, b)
+	if len(diffs) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(diffs))
+	}
+	for i, d := range diffs {
+		left, right := d.SideBySide()
+		if d.Number != expected[i].number || d.Equal() != expected[i].equal || left != expected[i].left || right != expected[i].right {
+			t.Errorf("line %d: expected %+v, got %d %v %q %q", i, expected[i], d.Number, d.Equal(), left, right)
+		}
+	}
+}
+
+func TestUpperCase(t *testing.T) {
+	if s := FBUpperCase("10 print \"a\\xB7\":rem ア"); s != "10 PRINT \"A\\xB7\":REM ア" {
+		t.Errorf("unexpected %q", s)
+	}
+}
+
+func TestMergeListings(t *testing.T) {
+	a := "#name BALL\n#title Bouncing ball\n#dialect v2.1\n\n// setup\n10 cls // clear\n20 print \"HELLO\"\n\n// the loop\n30 goto 20\n// end\n"
+	b := "#name BOLL\n10 CLS\n20 PRINT \"HELLQ\"\n25 X=1 // added\n30 GOTO 20\n40 END\n"
+	programA, _ := FBParseProgramWithOptions(a, DialectV2_1, FBParseOptions{UpperCase: true})
+	programB, _ := FBParseProgramWithOptions(b, DialectV2_1, FBParseOptions{UpperCase: true})
+	diffs := FBCompare(programA, programB)
+	names := [2]string{"A.txt", "B.txt"}
+
+	merged, conflicts := FBMergeListings(a, b, names, diffs, func(d FBLineDiff) string {
+		return "b"
+	})
+	expected := "#name BALL\n#title Bouncing ball\n#dialect v2.1\n\n// setup\n10 CLS // clear\n20 PRINT \"HELLQ\"\n25 X=1 // added\n\n// the loop\n30 GOTO 20\n40 END\n// end\n"
+	if merged != expected || conflicts != 0 {
+		t.Errorf("expected %q, got %q with %d conflicts", expected, merged, conflicts)
+	}
+
+	merged, conflicts = FBMergeListings(a, b, names, diffs, func(d FBLineDiff) string {
+		if d.Number == 20 {
+			return ""
+		}
+		return "a"
+	})
+	expected = "#name BALL\n#title Bouncing ball\n#dialect v2.1\n\n// setup\n10 CLS // clear\n<<<<<<< A.txt\n20 PRINT \"HELLO\"\n=======\n20 PRINT \"HELLQ\"\n>>>>>>> B.txt\n\n// the loop\n30 GOTO 20\n// end\n"
+	if merged != expected || conflicts != 1 {
+		t.Errorf("expected %q, got %q with %d conflicts", expected, merged, conflicts)
+	}
+
+	// lines are merged in number order, even if typed out of order
+	a = "300 END\n10 CLS\n20 A=1\n"
+	b = "10 CLS\n15 PRINT\n20 A=1\n300 END\n"
+	programA, _ = FBParseProgramWithOptions(a, DefaultFBDialect, FBParseOptions{UpperCase: true})
+	programB, _ = FBParseProgramWithOptions(b, DefaultFBDialect, FBParseOptions{UpperCase: true})
+	diffs = FBCompare(programA, programB)
+	if len(diffs) != 4 {
+		t.Fatalf("expected 4 lines, got %d", len(diffs))
+	}
+	merged, conflicts = FBMergeListings(a, b, names, diffs, func(d FBLineDiff) string {
+		return "b"
+	})
+	if expected := "10 CLS\n15 PRINT\n20 A=1\n300 END\n"; merged != expected || conflicts != 0 {
+		t.Errorf("expected %q, got %q with %d conflicts", expected, merged, conflicts)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/ktnyt/go-moji"
+)
+
+type fbConfusableChecker struct {
+	sourceLine int
+	line       []rune
+	dialect    FBDialect
+	diags      []FBDiagnostic
+}
+
+func (c *fbConfusableChecker) report(pos, length int, suggestion string, format string, args ...interface{}) {
+	c.diags = append(c.diags, FBDiagnostic{
+		Line:       c.sourceLine,
+		Column:     pos + 1,
+		Text:       string(c.line[pos : pos+length]),
+		Severity:   SeverityWarning,
+		Kind:       DiagConfusable,
+		Message:    fmt.Sprintf(format, args...),
+		Suggestion: suggestion,
+	})
+}
+
+func isFBLetter(r rune) bool {
+	return r >= 'A' && r <= 'Z'
+}
+
+func isFBDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isKatakana(r rune) bool {
+	return (r >= 0x30A1 && r <= 0x30FA) || (r >= 0xFF66 && r <= 0xFF9D)
+}
+
+// keywordAt returns the length of the keyword starting with a letter at
+// the given position, or 0 if there is none. As when crunching, the longest
+// keyword is taken.
+func (c *fbConfusableChecker) keywordAt(pos int) int {
+	if !isFBLetter(c.line[pos]) {
+		return 0
+	}
+	_, n := fbKeywordPrefix(string(c.line[pos:]), c.dialect)
+	return n
+}
+
+// checkNumber checks the number starting at pos for the letters O and I,
+// returning its length.
+func (c *fbConfusableChecker) checkNumber(pos int) int {
+	end := pos
+	for end < len(c.line) {
+		r := c.line[end]
+		if !isFBDigit(r) && !((r == 'O' || r == 'I') && c.keywordAt(end) == 0) {
+			break
+		}
+		end++
+	}
+	// letters at the end may start a name instead
+	if end < len(c.line) && isFBLetter(c.line[end]) {
+		for end > pos && !isFBDigit(c.line[end-1]) {
+			end--
+		}
+	}
+	number := string(c.line[pos:end])
+	if fixed := strings.NewReplacer("O", "0", "I", "1").Replace(number); fixed != number {
+		c.report(pos, end-pos, fixed, "letter in number %s, did you mean %s?", number, fixed)
+	}
+	return end - pos
+}
+
+// checkName checks the name starting at pos for the digits 0 and 1
+// between letters, returning its length.
+func (c *fbConfusableChecker) checkName(pos int) int {
+	end := pos
+	for end < len(c.line) && (isFBLetter(c.line[end]) || isFBDigit(c.line[end])) {
+		end++
+	}
+	fixed := make([]rune, end-pos)
+	copy(fixed, c.line[pos:end])
+	for i := 1; i < len(fixed)-1; i++ {
+		if isFBLetter(fixed[i-1]) && isFBLetter(c.line[pos+i+1]) {
+			if fixed[i] == '0' {
+				fixed[i] = 'O'
+			} else if fixed[i] == '1' {
+				fixed[i] = 'I'
+			}
+		}
+	}
+	if name := string(c.line[pos:end]); name != string(fixed) {
+		c.report(pos, end-pos, string(fixed), "digit in name %s, did you mean %s?", name, string(fixed))
+	}
+	return end - pos
+}
+
+// checkKana checks katakana in strings, comments and DATA.
+func (c *fbConfusableChecker) checkKana(pos int) {
+	r := c.line[pos]
+	if r >= 0xFF66 && r <= 0xFF9D {
+		full := moji.Convert(string(r), moji.HK, moji.ZK)
+		c.report(pos, 1, full, "half-width katakana %c is read as %s", r, full)
+		return
+	}
+
+	prev, next := rune(0), rune(0)
+	if pos > 0 {
+		prev = c.line[pos-1]
+	}
+	if pos+1 < len(c.line) {
+		next = c.line[pos+1]
+	}
+	small := strings.ContainsRune("ャュョァィゥェォ", next)
+	switch {
+	case r == 'ン' && (!isKatakana(prev) && prev != 'ー'):
+		c.report(pos, 1, "ソ", "no word starts with ン, did you mean ソ?")
+	case r == 'ン' && (next == 'ー' || small):
+		c.report(pos, 1, "ソ", "ン is not followed by %c, did you mean ソ?", next)
+	case r == 'ツ' && strings.ContainsRune("ャュョ", next):
+		c.report(pos, 1, "シ", "ツ is not followed by %c, did you mean シ?", next)
+	case r == 'ー' && !isKatakana(prev):
+		c.report(pos, 1, "-", "long vowel mark ー after %s, did you mean -?", describeRune(prev))
+	}
+}
+
+func describeRune(r rune) string {
+	if r == 0 {
+		return "nothing"
+	}
+	return string(r)
+}
+
+func (c *fbConfusableChecker) check() {
+	pos := 0
+	for pos < len(c.line) && (c.line[pos] == ' ' || c.line[pos] == '\t') {
+		pos++
+	}
+	for pos < len(c.line) && isFBDigit(c.line[pos]) {
+		pos++
+	}
+
+	inString, inComment, inData := false, false, false
+	for pos < len(c.line) {
+		r := c.line[pos]
+		if inString || inComment || inData {
+			if r == '"' && !inComment {
+				inString = !inString
+			} else if r == ':' && inData && !inString {
+				inData = false
+			} else if isKatakana(r) || r == 'ー' {
+				c.checkKana(pos)
+			} else if inData && !inString && isFBDigit(r) && (pos == 0 || !isFBLetter(c.line[pos-1])) {
+				pos += c.checkNumber(pos)
+				continue
+			}
+			pos++
+			continue
+		}
+
+		rest := string(c.line[pos:])
+		switch {
+		case r == '"':
+			inString = true
+		case r == '\'' || strings.HasPrefix(rest, "REM"):
+			inComment = true
+		case strings.HasPrefix(rest, "DATA"):
+			inData = true
+			pos += 4
+			continue
+		case r == 'ー':
+			c.report(pos, 1, "-", "long vowel mark ー outside a string, did you mean -?")
+		case isFBDigit(r) && (pos == 0 || !isFBLetter(c.line[pos-1])):
+			pos += c.checkNumber(pos)
+			continue
+		case isFBLetter(r):
+			if n := c.keywordAt(pos); n > 0 {
+				// skip keywords whole, so that a number follows, as in
+				// PRINT1O
+				pos += n
+				if pos < len(c.line) && isFBDigit(c.line[pos]) {
+					pos += c.checkNumber(pos)
+				}
+				continue
+			}
+			pos += c.checkName(pos)
+			continue
+		case unicode.Is(unicode.Katakana, r):
+			c.checkKana(pos)
+		}
+		pos++
+	}
+}
+
+// FBCheckConfusables checks a program listing for characters which are
+// easily mistaken for others when typing it in, judging by where they
+// appear: O and I in numbers, 0 and 1 inside names, ソ/ン and シ/ツ where
+// the other is far more likely, ー outside of words and half-width kana.
+// Keywords are those of the given dialect. // comments and directives are
+// not checked.
+func FBCheckConfusables(s string, dialect FBDialect) []FBDiagnostic {
+	var diags []FBDiagnostic
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		// // comments and directives, such as #title, are not typed in
+		if strings.HasPrefix(strings.TrimLeft(line, " \t"), "#") {
+			continue
+		}
+		if start := fbCommentStart(line); start >= 0 {
+			line = line[:start]
+		}
+		c := fbConfusableChecker{sourceLine: i + 1, line: []rune(line), dialect: dialect}
+		c.check()
+		diags = append(diags, c.diags...)
+	}
+	return diags
+}
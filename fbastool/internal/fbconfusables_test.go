@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import "testing"
+
+func TestCheckConfusables(t *testing.T) {
+	text := "#title B0X DEMO 1O\n" +
+		"10 A=1O:GOTO 2O0\n" +
+		"20 G0SUB 100:PR1NT A1\n" +
+		"30 IF A=1OR B=2 THEN 10\n" +
+		"40 PRINT \"ンコア シャツ ツャ ｱ\"\n" +
+		"50 DATA 1O,ABO\n" +
+		"60 A=5ー3\n" +
+		"70 POSITION1O,5,5\n" +
+		"// G0TO 1O\n" +
+		"90 PRINT \"//\";1O // PR1NT 1O\n"
+	expected := []struct {
+		line, column int
+		suggestion   string
+	}{
+		{2, 6, "10"},
+		{2, 14, "200"},
+		{3, 4, "GOSUB"},
+		{3, 14, "PRINT"},
+		{5, 11, "ソ"},
+		{5, 19, "シ"},
+		{5, 22, "ア"},
+		{6, 9, "10"},
+		{7, 7, "-"},
+		{8, 12, "10"},
+		{10, 15, "10"},
+	}
+
+	diags := FBCheckConfusables(text, DefaultFBDialect)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, d := range diags {
+		if d.Line != expected[i].line || d.Column != expected[i].column || d.Suggestion != expected[i].suggestion {
+			t.Errorf("diagnostic %d: expected %+v, got %+v", i, expected[i], d)
+		}
+	}
+}
+
+func TestCheckConfusablesDialect(t *testing.T) {
+	// ERROR is only a keyword in V3; before that, it starts a name
+	for _, c := range []struct {
+		dialect    FBDialect
+		suggestion string
+	}{
+		{DialectV3, "10"},
+		{DialectV2_1, "ERRORIO"},
+	} {
+		diags := FBCheckConfusables("10 ERROR1O\n", c.dialect)
+		if len(diags) != 1 || diags[0].Suggestion != c.suggestion {
+			t.Errorf("%v: expected the suggestion %s, got %v", c.dialect, c.suggestion, diags)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FBCommentMode is what crunching does with REM and ' comments.
+type FBCommentMode int
+
+const (
+	CommentsKeep FBCommentMode = iota
+	CommentsShorten
+	CommentsDrop
+)
+
+func ParseFBCommentMode(s string) (FBCommentMode, error) {
+	switch s {
+	case "keep":
+		return CommentsKeep, nil
+	case "shorten":
+		return CommentsShorten, nil
+	case "drop":
+		return CommentsDrop, nil
+	default:
+		return CommentsKeep, fmt.Errorf("unknown comment mode %s (expected keep, shorten or drop)", s)
+	}
+}
+
+type FBCrunchOptions struct {
+	Rename    bool
+	Comments  FBCommentMode
+	Spaces    bool
+	Merge     bool
+	MaxLength int // longest merged line, in characters as typed in
+}
+
+// FBCrunchStep is a transformation done by Crunch, with the number of
+// bytes it saved.
+type FBCrunchStep struct {
+	Name  string
+	Saved int
+}
+
+// Size returns the size of the program in memory, in bytes.
+func (p *FBProgram) Size() int {
+	size := 1
+	for _, l := range p.Lines {
+		size += 3 + len(l.Bytes())
+	}
+	return size
+}
+
+// Crunch makes the program smaller without changing what it does.
+func (p *FBProgram) Crunch(opts FBCrunchOptions) []FBCrunchStep {
+	var steps []FBCrunchStep
+	step := func(name string, f func()) {
+		size := p.Size()
+		f()
+		steps = append(steps, FBCrunchStep{Name: name, Saved: size - p.Size()})
+	}
+	if opts.Rename {
+		step("shorten names", p.shortenNames)
+		step("rename variables", p.renameNames)
+	}
+	if opts.Comments != CommentsKeep {
+		step("comments", func() { p.crunchComments(opts.Comments) })
+	}
+	if opts.Spaces {
+		step("spaces", p.crunchSpaces)
+	}
+	if opts.Merge {
+		step("merge lines", func() { p.mergeLines(opts.MaxLength) })
+	}
+	return steps
+}
+
+// retarget rewrites references to lines according to the mapping.
+func (p *FBProgram) retarget(mapping map[int]int) {
+	for i := range p.Lines {
+		l := &p.Lines[i]
+		for j, t := range l.Tokens {
+			if target, ok := mapping[t.Value()]; ok && t.Kind == TokenLineNumber {
+				token := NewFBNumberToken(TokenLineNumber, target)
+				token.Column = t.Column
+				l.Tokens[j] = token
+			}
+		}
+	}
+}
+
+func (p *FBProgram) targets() map[int]bool {
+	targets := make(map[int]bool)
+	for _, r := range p.References() {
+		targets[r.Target] = true
+	}
+	return targets
+}
+
+// fbNameRun is a variable name in a line, as the tokens from start to end.
+type fbNameRun struct {
+	line       int
+	start, end int
+	name       string
+}
+
+func (r fbNameRun) base() string {
+	return strings.TrimSuffix(r.name, "$")
+}
+
+// nameRuns finds the variable names in the program, outside of DATA and
+// the letters following PALET.
+func (p *FBProgram) nameRuns() []fbNameRun {
+	var runs []fbNameRun
+	for i, l := range p.Lines {
+		data := false
+		palet := false
+		for j := 0; j < len(l.Tokens); j++ {
+			t := l.Tokens[j]
+			if t.IsKeyword("DATA") {
+				data = true
+			} else if t.isRawByte(":") {
+				data = false
+			}
+			if data || !isFBNameByte(t, true) {
+				if !t.isRawByte(" ") {
+					palet = t.IsKeyword("PALET")
+				}
+				continue
+			}
+			run := fbNameRun{line: i, start: j}
+			for j < len(l.Tokens) && isFBNameByte(l.Tokens[j], j > run.start) {
+				run.name += string(l.Tokens[j].Bytes[0])
+				j++
+			}
+			run.end = j
+			if j < len(l.Tokens) && l.Tokens[j].isRawByte("$") {
+				run.name += "$"
+			}
+			j--
+			if !palet {
+				runs = append(runs, run)
+			}
+			palet = false
+		}
+	}
+	return runs
+}
+
+// replaceNames replaces each variable name, without any $, with the one
+// returned by f.
+func (p *FBProgram) replaceNames(f func(base string) string) {
+	runs := p.nameRuns()
+	// replace from the end, so the positions of earlier names stay valid
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		base := f(r.base())
+		if base == r.base() {
+			continue
+		}
+		l := &p.Lines[r.line]
+		tokens := make([]FBToken, 0, len(l.Tokens))
+		tokens = append(tokens, l.Tokens[:r.start]...)
+		for k := 0; k < len(base); k++ {
+			tokens = append(tokens, FBToken{Kind: TokenRaw, Bytes: []byte{base[k]}, Column: l.Tokens[r.start].Column})
+		}
+		l.Tokens = append(tokens, l.Tokens[r.end:]...)
+	}
+}
+
+// shortenNames cuts names down to the two characters the interpreter
+// looks at.
+func (p *FBProgram) shortenNames() {
+	p.replaceNames(func(base string) string {
+		return fbName{name: base}.key()
+	})
+}
+
+// renameNames gives the most used two-character names any unused
+// one-letter names. Once the letters run out, the other names are left
+// as they are.
+func (p *FBProgram) renameNames() {
+	used := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, r := range p.nameRuns() {
+		used[r.base()] = true
+		if len(r.base()) == 2 {
+			counts[r.base()]++
+		}
+	}
+	var long []string
+	for base := range counts {
+		long = append(long, base)
+	}
+	sort.Slice(long, func(i, j int) bool {
+		if counts[long[i]] != counts[long[j]] {
+			return counts[long[i]] > counts[long[j]]
+		}
+		return long[i] < long[j]
+	})
+	mapping := make(map[string]string)
+	letter := byte('A')
+	for _, base := range long {
+		for letter <= 'Z' && used[string(letter)] {
+			letter++
+		}
+		if letter > 'Z' {
+			break
+		}
+		mapping[base] = string(letter)
+		letter++
+	}
+
+	p.replaceNames(func(base string) string {
+		if short, ok := mapping[base]; ok {
+			return short
+		}
+		return base
+	})
+}
+
+// crunchComments shortens comments to just REM or ', or drops them. Lines
+// left empty are removed, with jumps to them going to the next line.
+func (p *FBProgram) crunchComments(mode FBCommentMode) {
+	var lines []FBLine
+	mapping := make(map[int]int)
+	for i := len(p.Lines) - 1; i >= 0; i-- {
+		l := p.Lines[i]
+		n := len(l.Tokens)
+		if n == 0 || l.Tokens[n-1].Kind != TokenComment {
+			lines = append(lines, l)
+			continue
+		}
+		comment := l.Tokens[n-1]
+		comment.Bytes = comment.Bytes[:1]
+		l.Tokens = append(l.Tokens[:n-1:n-1], comment)
+
+		if mode == CommentsDrop {
+			// drop the comment along with the ':' and spaces before it
+			end := n - 1
+			for end > 0 && l.Tokens[end-1].isRawByte(" ") {
+				end--
+			}
+			if end > 0 && l.Tokens[end-1].isRawByte(":") {
+				l.Tokens = l.Tokens[:end-1]
+			} else if end > 0 && comment.Bytes[0] == '\'' && !l.Tokens[end-1].IsKeyword("THEN") {
+				l.Tokens = l.Tokens[:end]
+			} else if end == 0 && len(lines) > 0 {
+				mapping[l.Number] = lines[len(lines)-1].Number
+				continue
+			}
+		}
+		lines = append(lines, l)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	p.Lines = lines
+	p.retarget(mapping)
+}
+
+func (t FBToken) isWord() bool {
+	return t.IsNumber() || isFBNameByte(t, false)
+}
+
+// crunchSpaces removes spaces outside of strings, comments and DATA,
+// except where they separate two names or numbers.
+func (p *FBProgram) crunchSpaces() {
+	for i := range p.Lines {
+		l := &p.Lines[i]
+		var tokens []FBToken
+		data := false
+		for j, t := range l.Tokens {
+			if t.IsKeyword("DATA") {
+				data = true
+			} else if t.isRawByte(":") {
+				data = false
+			}
+			if t.isRawByte(" ") && !data {
+				next := j + 1
+				for next < len(l.Tokens) && l.Tokens[next].isRawByte(" ") {
+					next++
+				}
+				if len(tokens) == 0 || next == len(l.Tokens) || !tokens[len(tokens)-1].isWord() || !l.Tokens[next].isWord() {
+					continue
+				}
+			}
+			tokens = append(tokens, t)
+		}
+		l.Tokens = tokens
+	}
+}
+
+// canContinue returns true if a statement can be added to the end of the
+// line: the line has no IF, whose condition would cover it, and no
+// comment, which would swallow it.
+func (l *FBLine) canContinue() bool {
+	for _, t := range l.Tokens {
+		if t.Kind == TokenComment || t.IsKeyword("IF") {
+			return false
+		}
+	}
+	return len(l.Tokens) > 0
+}
+
+// mergeLines joins lines onto the line before them, where nothing jumps to
+// them.
+func (p *FBProgram) mergeLines(maxLength int) {
+	targets := p.targets()
+	var lines []FBLine
+	for _, l := range p.Lines {
+		if len(lines) > 0 && !targets[l.Number] {
+			prev := &lines[len(lines)-1]
+			if prev.canContinue() {
+				merged := *prev
+				merged.Tokens = append(append(append([]FBToken(nil), prev.Tokens...), FBToken{Kind: TokenRaw, Bytes: []byte{':'}}), l.Tokens...)
+				if len(merged.Bytes()) < 253 && len(FBScreenCells(merged.PlainString())) <= maxLength {
+					*prev = merged
+					continue
+				}
+			}
+		}
+		lines = append(lines, l)
+	}
+	p.Lines = lines
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCrunch(t *testing.T) {
+	for _, test := range []struct {
+		source   string
+		opts     FBCrunchOptions
+		expected string
+	}{
+		{
+			"10 REM GAME\n20 PTS=0:LIVES=3 ' SETUP\n30 FOR I=1 TO 10:PTS=PTS+I:NEXT\n40 PRINT \"PTS\";PTS;LI$\n50 GOTO 10\n",
+			FBCrunchOptions{Rename: true, Comments: CommentsDrop, Spaces: true, Merge: true, MaxLength: FBMaxEditorLineLength},
+			"20 A=0:B=3:FORI=1TO10:A=A+I:NEXT:PRINT\"PTS\";A;B$:GOTO20\n",
+		},
+		{
+			// IF covers the rest of its line, and jump targets stay lines
+			"10 IF A THEN 'X\n20 PRINT A:GOTO 40\n30 PRINT B\n40 PRINT C REM X\n50 END\n",
+			FBCrunchOptions{Comments: CommentsDrop, Merge: true, MaxLength: FBMaxEditorLineLength},
+			"10 IF A THEN '\n20 PRINT A:GOTO 40:PRINT B\n40 PRINT C REM\n50 END\n",
+		},
+		{
+			// letters after PALET are not names, spaces in DATA are kept
+			"10 PALETS0,15,1,2,3:DATA A B , C\n20 PRINT DIST , NAME\n",
+			FBCrunchOptions{Rename: true, Spaces: true},
+			"10 PALETS0,15,1,2,3:DATA A B , C\n20 PRINTA,B\n",
+		},
+		{
+			// targets of RESTORE and ON ... GOTO/GOSUB stay lines
+			"10 RESTORE 30\n20 READ A\n30 DATA 1\n40 ON A GOTO 60,70\n50 END\n60 PRINT 1\n70 PRINT 2\n80 ON A GOSUB 90\n90 RETURN\n",
+			FBCrunchOptions{Merge: true, MaxLength: FBMaxEditorLineLength},
+			"10 RESTORE 30:READ A\n30 DATA 1:ON A GOTO 60,70:END\n60 PRINT 1\n70 PRINT 2:ON A GOSUB 90\n90 RETURN\n",
+		},
+		{
+			"10 DATA 1\n20 DATA 2\n",
+			FBCrunchOptions{Merge: true, MaxLength: 16},
+			"10 DATA 1:DATA 2\n",
+		},
+		{
+			"10 DATA 1\n20 DATA 2\n",
+			FBCrunchOptions{Merge: true, MaxLength: 15},
+			"10 DATA 1\n20 DATA 2\n",
+		},
+	} {
+		program, diags := FBParseProgram(test.source, DefaultFBDialect)
+		if len(diags) != 0 {
+			t.Fatal(diags)
+		}
+		size := program.Size()
+		steps := program.Crunch(test.opts)
+		if program.String() != test.expected {
+			t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", test.expected, program.String())
+		}
+		saved := 0
+		for _, s := range steps {
+			saved += s.Saved
+		}
+		if saved != size-program.Size() {
+			t.Errorf("steps saved %d bytes, program shrank by %d", saved, size-program.Size())
+		}
+	}
+}
+
+func TestCrunchSteps(t *testing.T) {
+	program, _ := FBParseProgram("10 REM ABC\n20 PRINT 1\n", DefaultFBDialect)
+	steps := program.Crunch(FBCrunchOptions{Comments: CommentsShorten, Spaces: true})
+	expected := []FBCrunchStep{{"comments", 4}, {"spaces", 1}}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected steps %v, got %v", expected, steps)
+	}
+}
+
+func TestCrunchNamesLettersUsed(t *testing.T) {
+	// with every one-letter name taken, names are only shortened
+	source := "10 A=1:B=1:C=1:D=1:E=1:F=1:G=1:H=1:I=1:J=1:K=1:L=1:M=1\n20 N=1:O=1:P=1:Q=1:R=1:S=1:T=1:U=1:V=1:W=1:X=1:Y=1:Z=1\n30 XYZ=1:BLUB=XYZ+BLUB\n"
+	program, diags := FBParseProgram(source, DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	steps := program.Crunch(FBCrunchOptions{Rename: true})
+	expected := []FBCrunchStep{{"shorten names", 6}, {"rename variables", 0}}
+	if !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected steps %v, got %v", expected, steps)
+	}
+	if line := program.Lines[2].PlainString(); line != "30 XY=1:BL=XY+BL" {
+		t.Errorf("unexpected line %s", line)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import "fmt"
+
+type FBSeverity int
+type FBDiagnosticKind int
+
+const (
+	SeverityWarning FBSeverity = iota
+	SeverityError
+)
+
+const (
+	DiagMissingLineNumber FBDiagnosticKind = iota
+	DiagInvalidLineNumber
+	DiagEmptyLine
+	DiagInvalidCharacter
+	DiagValueOutOfRange
+	DiagLineTooLong
+	DiagInvalidEscape
+	DiagUnsupportedKeyword
+	DiagMissingTarget
+	DiagUnreachable
+	DiagForWithoutNext
+	DiagUseBeforeAssignment
+	DiagNameCollision
+	DiagUnclosedString
+	DiagConfusable
+	DiagDuplicateLine
+	DiagUnknownLabel
+	DiagDuplicateLabel
+	DiagDirective
+	DiagLineCollision
+	DiagBlock
+)
+
+func (s FBSeverity) String() string {
+	if s == SeverityError {
+		return "error"
+	} else {
+		return "warning"
+	}
+}
+
+func (k FBDiagnosticKind) String() string {
+	switch k {
+	case DiagMissingLineNumber:
+		return "missing-line-number"
+	case DiagInvalidLineNumber:
+		return "invalid-line-number"
+	case DiagEmptyLine:
+		return "empty-line"
+	case DiagInvalidCharacter:
+		return "invalid-character"
+	case DiagValueOutOfRange:
+		return "value-out-of-range"
+	case DiagLineTooLong:
+		return "line-too-long"
+	case DiagInvalidEscape:
+		return "invalid-escape"
+	case DiagUnsupportedKeyword:
+		return "unsupported-keyword"
+	case DiagMissingTarget:
+		return "missing-target"
+	case DiagUnreachable:
+		return "unreachable"
+	case DiagForWithoutNext:
+		return "for-without-next"
+	case DiagUseBeforeAssignment:
+		return "use-before-assignment"
+	case DiagNameCollision:
+		return "name-collision"
+	case DiagUnclosedString:
+		return "unclosed-string"
+	case DiagConfusable:
+		return "confusable"
+	case DiagDuplicateLine:
+		return "duplicate-line"
+	case DiagUnknownLabel:
+		return "unknown-label"
+	case DiagDuplicateLabel:
+		return "duplicate-label"
+	case DiagDirective:
+		return "directive"
+	case DiagLineCollision:
+		return "line-collision"
+	case DiagBlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
+
+// FBDiagnostic is a problem found in a source listing. Line and Column are
+// 1-based; Column counts characters, not bytes. File is set for problems
+// found in files other than the one being read, such as included ones.
+type FBDiagnostic struct {
+	File       string
+	Line       int
+	Column     int
+	Text       string
+	Severity   FBSeverity
+	Kind       FBDiagnosticKind
+	Message    string
+	Suggestion string // likely intended text, if known
+}
+
+func (d FBDiagnostic) Error() string {
+	return d.Format("")
+}
+
+// Format renders the diagnostic compiler-style, as in
+// "file.txt:12:7: error: message". The position is left out for problems
+// found in tokenized programs, which have none.
+func (d FBDiagnostic) Format(filename string) string {
+	if d.File != "" {
+		filename = d.File
+	}
+	s := fmt.Sprintf("%v: %s", d.Severity, d.Message)
+	if d.Line > 0 {
+		s = fmt.Sprintf("%d:%d: %s", d.Line, d.Column, s)
+		if filename != "" {
+			s = filename + ":" + s
+		}
+	} else if filename != "" {
+		s = filename + ": " + s
+	}
+	return s
+}
+
+func FBDiagnosticErrorCount(diags []FBDiagnostic) int {
+	count := 0
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FBDialect is a revision of Family BASIC. Programs are tokenized using
+// the keywords known to the selected revision only.
+type FBDialect int
+
+const (
+	DialectV1 FBDialect = iota
+	DialectV2_0
+	DialectV2_1
+	DialectV3
+)
+
+const DefaultFBDialect = DialectV3
+
+var fbDialectNames = map[FBDialect]string{
+	DialectV1:   "v1",
+	DialectV2_0: "v2.0",
+	DialectV2_1: "v2.1",
+	DialectV3:   "v3",
+}
+
+func (d FBDialect) String() string {
+	if name, ok := fbDialectNames[d]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseFBDialect parses a dialect name, such as "v2.1" or "v3".
+func ParseFBDialect(s string) (FBDialect, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if !strings.HasPrefix(s, "v") {
+		s = "v" + s
+	}
+	if s == "v2" {
+		return DialectV2_1, nil
+	}
+	for d, name := range fbDialectNames {
+		if s == name || s == name+"a" {
+			return d, nil
+		}
+	}
+	return DefaultFBDialect, fmt.Errorf("unknown dialect %s (expected v1, v2.0, v2.1 or v3)", s)
+}
+
+// fbV3Keyword returns true for keywords added in Family BASIC V3. Enri's
+// notes do not document any keyword differences between V1, V2.0 and
+// V2.1, so they share the same table.
+func fbV3Keyword(id byte) bool {
+	return (id >= 0xB1 && id <= 0xC0) || (id >= 0xE2 && id <= 0xE6)
+}
+
+func (d FBDialect) HasKeyword(id byte) bool {
+	if _, ok := idToKeywordMap[id]; !ok {
+		return false
+	}
+	return d >= DialectV3 || !fbV3Keyword(id)
+}
+
+// UnsupportedKeywords returns the keywords used by the program which the
+// given dialect lacks, in order of first use.
+func (p *FBProgram) UnsupportedKeywords(dialect FBDialect) []string {
+	var keywords []string
+	found := make(map[byte]bool)
+	for _, l := range p.Lines {
+		for _, t := range l.Tokens {
+			id := t.Bytes[0]
+			if (t.Kind == TokenKeyword || t.Kind == TokenUnknown) && !found[id] && !dialect.HasKeyword(id) {
+				if keyword, ok := idToKeywordMap[id]; ok {
+					keywords = append(keywords, keyword)
+					found[id] = true
+				}
+			}
+		}
+	}
+	return keywords
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FBSpacing is how spaces around keywords or operators are formatted.
+// Spaces are stored in the program, so anything but SpacingKeep changes
+// its bytes.
+type FBSpacing int
+
+const (
+	SpacingKeep FBSpacing = iota
+	SpacingNone
+	SpacingAround
+)
+
+func ParseFBSpacing(s string) (FBSpacing, error) {
+	switch s {
+	case "keep":
+		return SpacingKeep, nil
+	case "none":
+		return SpacingNone, nil
+	case "around":
+		return SpacingAround, nil
+	default:
+		return SpacingKeep, fmt.Errorf("unknown spacing %s (expected keep, none or around)", s)
+	}
+}
+
+// SortLines sorts the lines by number. Where a number appears more than
+// once, the last line is kept, as when typing it in; the lines dropped
+// are returned.
+func (p *FBProgram) SortLines() []FBLine {
+	last := make(map[int]int)
+	for i, l := range p.Lines {
+		last[l.Number] = i
+	}
+	var lines, replaced []FBLine
+	for i, l := range p.Lines {
+		if last[l.Number] == i {
+			lines = append(lines, l)
+		} else {
+			replaced = append(replaced, l)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Number < lines[j].Number
+	})
+	p.Lines = lines
+	return replaced
+}
+
+// isOperator returns true for keywords written with symbols, such as + or
+// <=, rather than letters.
+func (t FBToken) isOperator() bool {
+	kw := t.Keyword()
+	return t.Kind == TokenKeyword && kw != "" && (kw[0] < 'A' || kw[0] > 'Z')
+}
+
+func (t FBToken) isRawByte(chars string) bool {
+	return t.Kind == TokenRaw && len(t.Bytes) == 1 && strings.IndexByte(chars, t.Bytes[0]) >= 0
+}
+
+// Respace changes the spaces around keywords and operators. Spaces in
+// strings, comments and DATA are left alone.
+func (p *FBProgram) Respace(keywords, operators FBSpacing) {
+	for i := range p.Lines {
+		p.Lines[i].respace(keywords, operators)
+	}
+}
+
+func (l *FBLine) respace(keywords, operators FBSpacing) {
+	spacing := func(t *FBToken) FBSpacing {
+		if t == nil || t.Kind != TokenKeyword {
+			return SpacingKeep
+		} else if t.isOperator() {
+			return operators
+		}
+		return keywords
+	}
+
+	// nonSpace returns the first token from i on, stepping by step, which
+	// is not a space
+	nonSpace := func(i, step int) *FBToken {
+		for ; i >= 0 && i < len(l.Tokens); i += step {
+			if !l.Tokens[i].isRawByte(" ") {
+				return &l.Tokens[i]
+			}
+		}
+		return nil
+	}
+
+	// drop the spaces next to keywords and operators being respaced
+	var tokens []FBToken
+	var prev *FBToken
+	data := false
+	for i, t := range l.Tokens {
+		if t.IsKeyword("DATA") {
+			data = true
+		} else if t.isRawByte(":") {
+			data = false
+		}
+		if t.isRawByte(" ") && !data {
+			next := nonSpace(i+1, 1)
+			if spacing(prev) != SpacingKeep || spacing(next) != SpacingKeep {
+				continue
+			}
+			// both sides of a : are spaced alike, by the statements
+			// around it
+			colon := -1
+			if next != nil && next.isRawByte(":") {
+				colon = i + 1
+				for l.Tokens[colon].isRawByte(" ") {
+					colon++
+				}
+			} else if prev != nil && prev.isRawByte(":") {
+				colon = i - 1
+				for l.Tokens[colon].isRawByte(" ") {
+					colon--
+				}
+			}
+			if colon >= 0 && (spacing(nonSpace(colon-1, -1)) != SpacingKeep || spacing(nonSpace(colon+1, 1)) != SpacingKeep) {
+				continue
+			}
+		}
+		tokens = append(tokens, t)
+		prev = &l.Tokens[i]
+	}
+
+	// then put single spaces around those which should have them
+	space := FBToken{Kind: TokenRaw, Bytes: []byte{' '}}
+	l.Tokens = nil
+	data = false
+	for i, t := range tokens {
+		var prev, next *FBToken
+		if i > 0 {
+			prev = &tokens[i-1]
+		}
+		if i+1 < len(tokens) {
+			next = &tokens[i+1]
+		}
+		unary := t.isOperator() && (t.IsKeyword("-") || t.IsKeyword("+")) &&
+			(prev == nil || prev.Kind == TokenKeyword || prev.isRawByte("(,;:"))
+		if spacing(&t) == SpacingAround && !data {
+			if prev != nil && !prev.isRawByte(" (,;:") && !l.Tokens[len(l.Tokens)-1].isRawByte(" ") {
+				l.Tokens = append(l.Tokens, space)
+			}
+			l.Tokens = append(l.Tokens, t)
+			// functions keep their parentheses attached
+			if next != nil && !unary && !next.isRawByte(" ),;:") && !(next.isRawByte("(") && !t.isOperator()) {
+				l.Tokens = append(l.Tokens, space)
+			}
+		} else {
+			l.Tokens = append(l.Tokens, t)
+		}
+		if t.IsKeyword("DATA") {
+			data = true
+		} else if t.isRawByte(":") {
+			data = false
+		}
+	}
+}
+
+// FBFormatOptions controls how FBFormat formats a listing.
+type FBFormatOptions struct {
+	Keywords  FBSpacing
+	Operators FBSpacing
+}
+
+// FBFormat returns the canonical form of a listing: keywords in upper
+// case, kana in full width, lines sorted by number and spacing as given by
+// the options. Unless the options ask for spaces to be changed, the
+// result tokenizes to the same program.
+func FBFormat(s string, dialect FBDialect, opts FBFormatOptions) (*FBProgram, []FBDiagnostic) {
+	program, diags := FBParseProgramWithOptions(s, dialect, FBParseOptions{UpperCase: true})
+	for _, l := range program.SortLines() {
+		diags = append(diags, FBDiagnostic{Line: l.Source, Column: 1, Severity: SeverityWarning, Kind: DiagDuplicateLine, Message: fmt.Sprintf("line %d is replaced by a later line with the same number", l.Number)})
+	}
+	program.Respace(opts.Keywords, opts.Operators)
+	return program, diags
+}
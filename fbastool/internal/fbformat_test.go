@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	source := "30 print \"ｱｲｳ\";a\n10 for i=0 to 10\n20 next\n10 FOR I = 0 TO 9 : A=A+-1*2\n40 data 1, 2 ,ab:if a>=2 then print chr$(65);:goto 10\n50 print \"HELLO\" : goto 10\n"
+	program, diags := FBFormat(source, DefaultFBDialect, FBFormatOptions{})
+	if len(diags) != 1 || diags[0].Kind != DiagDuplicateLine || diags[0].Line != 2 {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+	expected := "10 FOR I = 0 TO 9 : A=A+-1*2\n20 NEXT\n30 PRINT \"アイウ\";A\n40 DATA 1, 2 ,AB:IF A>=2 THEN PRINT CHR$(65);:GOTO 10\n50 PRINT \"HELLO\" : GOTO 10\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+
+	// the bytes are those of the uppercase listing, as typed in
+	typed, _ := FBParseProgram("30 PRINT \"アイウ\";A\n10 FOR I = 0 TO 9 : A=A+-1*2\n20 NEXT\n40 DATA 1, 2 ,AB:IF A>=2 THEN PRINT CHR$(65);:GOTO 10\n50 PRINT \"HELLO\" : GOTO 10\n", DefaultFBDialect)
+	typed.SortLines()
+	a, _ := program.MarshalBinary()
+	b, _ := typed.MarshalBinary()
+	if !bytes.Equal(a, b) {
+		t.Errorf("formatting changed the program")
+	}
+
+	for _, test := range []struct {
+		keywords, operators FBSpacing
+		expected            string
+	}{
+		{SpacingAround, SpacingAround, "10 FOR I = 0 TO 9 : A = A + -1 * 2\n20 NEXT\n30 PRINT \"アイウ\";A\n40 DATA 1, 2 ,AB:IF A >= 2 THEN PRINT CHR$(65);:GOTO 10\n50 PRINT \"HELLO\":GOTO 10\n"},
+		{SpacingNone, SpacingKeep, "10 FORI = 0TO9 : A=A+-1*2\n20 NEXT\n30 PRINT\"アイウ\";A\n40 DATA 1, 2 ,AB:IFA>=2THENPRINTCHR$(65);:GOTO10\n50 PRINT\"HELLO\":GOTO10\n"},
+		{SpacingKeep, SpacingAround, "10 FOR I = 0 TO 9 : A = A + -1 * 2\n20 NEXT\n30 PRINT \"アイウ\";A\n40 DATA 1, 2 ,AB:IF A >= 2 THEN PRINT CHR$(65);:GOTO 10\n50 PRINT \"HELLO\" : GOTO 10\n"},
+	} {
+		program, _ := FBFormat(source, DefaultFBDialect, FBFormatOptions{Keywords: test.keywords, Operators: test.operators})
+		if program.String() != test.expected {
+			t.Errorf("%v/%v: mismatch\nexpected:\n%s\nactual:\n%s", test.keywords, test.operators, test.expected, program.String())
+		}
+		again, _ := FBFormat(program.String(), DefaultFBDialect, FBFormatOptions{Keywords: test.keywords, Operators: test.operators})
+		if again.String() != program.String() {
+			t.Errorf("%v/%v: formatting twice changed the result:\n%s", test.keywords, test.operators, again.String())
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fbProgramExecution is the execution address stored with BASIC programs
+// on tape.
+const fbProgramExecution = 0x2020
+
+// FBHeader describes the tape file a listing becomes, as given by
+// directives at its top, such as "#name GAME" or "#dialect v2.1".
+type FBHeader struct {
+	Name             string
+	Title            string // for printed listings
+	Dialect          FBDialect
+	HasDialect       bool
+	Type             FBFileType // 0 if not given
+	LoadAddress      uint16     // 0 if not given
+	ExecutionAddress uint16     // 0 if not given
+}
+
+// fbParseAddress parses an address written in decimal or hexadecimal
+// (&H6006, $6006 or 0x6006).
+func fbParseAddress(s string) (uint16, error) {
+	base := 10
+	for _, prefix := range []string{"&H", "&h", "$", "0x", "0X"} {
+		if strings.HasPrefix(s, prefix) {
+			s = s[len(prefix):]
+			base = 16
+			break
+		}
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %s", s)
+	}
+	return uint16(v), nil
+}
+
+// fbParseFileType parses the name of a file type, as in "BG-GRAPHICS".
+func fbParseFileType(s string) (FBFileType, error) {
+	for _, tp := range []FBFileType{FileTypeBasic, FileTypeBgGraphics} {
+		if strings.EqualFold(s, tp.String()) {
+			return tp, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid file type %s", s)
+}
+
+func isFBHeaderDirective(name string) bool {
+	switch name {
+	case "#name", "#title", "#dialect", "#type", "#load", "#exec":
+		return true
+	}
+	return false
+}
+
+// set applies a header directive.
+func (h *FBHeader) set(name string, args string) error {
+	args = strings.TrimSpace(args)
+	if !isFBHeaderDirective(name) {
+		return fmt.Errorf("unknown directive %s", name)
+	} else if args == "" {
+		return fmt.Errorf("%s expects a value", name)
+	}
+	var err error
+	switch name {
+	case "#name":
+		args = strings.Trim(args, "\"")
+		v, err := FBStringToBytes(strings.ToUpper(args))
+		if err != nil {
+			return err
+		} else if len(v) > 16 {
+			return fmt.Errorf("name %s is longer than 16 characters", args)
+		}
+		h.Name = args
+	case "#title":
+		h.Title = strings.Trim(args, "\"")
+	case "#dialect":
+		h.Dialect, err = ParseFBDialect(args)
+		h.HasDialect = err == nil
+	case "#type":
+		h.Type, err = fbParseFileType(args)
+	case "#load":
+		h.LoadAddress, err = fbParseAddress(args)
+	case "#exec":
+		h.ExecutionAddress, err = fbParseAddress(args)
+	}
+	return err
+}
+
+// String returns the header as directives, one per line.
+func (h FBHeader) String() string {
+	var s strings.Builder
+	if h.Name != "" {
+		fmt.Fprintf(&s, "#name %s\n", h.Name)
+	}
+	if h.Title != "" {
+		fmt.Fprintf(&s, "#title %s\n", h.Title)
+	}
+	if h.HasDialect {
+		fmt.Fprintf(&s, "#dialect %v\n", h.Dialect)
+	}
+	if h.Type != 0 {
+		fmt.Fprintf(&s, "#type %v\n", h.Type)
+	}
+	if h.LoadAddress != 0 {
+		fmt.Fprintf(&s, "#load &H%04X\n", h.LoadAddress)
+	}
+	if h.ExecutionAddress != 0 {
+		fmt.Fprintf(&s, "#exec &H%04X\n", h.ExecutionAddress)
+	}
+	return s.String()
+}
+
+// HasFileInfo returns true if the header sets any of the tape file
+// information.
+func (h FBHeader) HasFileInfo() bool {
+	return h.Name != "" || h.Type != 0 || h.LoadAddress != 0 || h.ExecutionAddress != 0
+}
+
+// FileInfo returns the tape file information of a program of the given
+// length. The type and addresses which are not given are those the
+// interpreter uses.
+func (h FBHeader) FileInfo(length int) FBFileInfo {
+	info := FBFileInfo{Type: FileTypeBasic, Length: uint16(length), LoadAddress: fbProgramAddress, ExecutionAddress: fbProgramExecution}
+	if h.Type != 0 {
+		info.Type = h.Type
+	}
+	if h.LoadAddress != 0 {
+		info.LoadAddress = h.LoadAddress
+	}
+	if h.ExecutionAddress != 0 {
+		info.ExecutionAddress = h.ExecutionAddress
+	}
+	info.SetName(h.Name)
+	return info
+}
+
+// FBHeaderFromFileInfo returns the header describing a program's tape
+// file; the type and addresses the interpreter uses anyway are left out.
+func FBHeaderFromFileInfo(info FBFileInfo) FBHeader {
+	h := FBHeader{Name: info.NameStr()}
+	if info.Type != FileTypeBasic {
+		h.Type = info.Type
+	}
+	if info.LoadAddress != fbProgramAddress {
+		h.LoadAddress = info.LoadAddress
+	}
+	if info.ExecutionAddress != fbProgramExecution {
+		h.ExecutionAddress = info.ExecutionAddress
+	}
+	return h
+}
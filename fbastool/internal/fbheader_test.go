@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import "testing"
+
+func TestParseHeader(t *testing.T) {
+	text := "#name Ball Game\n#title \"Bouncing ball\"\n#dialect v2.1\n#load &H6006\n#exec $2020\n// main\n10 ERROR 20\n"
+	program, diags := FBParseProgram(text, DialectV3)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	h := program.Header
+	if h.Name != "Ball Game" || h.Title != "Bouncing ball" || !h.HasDialect || h.Dialect != DialectV2_1 || h.LoadAddress != 0x6006 || h.ExecutionAddress != 0x2020 {
+		t.Errorf("unexpected header %+v", h)
+	}
+	// ERROR is not a keyword before V3
+	if program.Dialect != DialectV2_1 || program.Lines[0].Tokens[0].Kind != TokenRaw {
+		t.Errorf("expected the listing to be read as %v, got %v", DialectV2_1, program.Lines[0].Tokens)
+	}
+	expected := "#name Ball Game\n#title Bouncing ball\n#dialect v2.1\n#load &H6006\n#exec &H2020\n// main\n10 ERROR 20\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+
+	info := h.FileInfo(20)
+	if info.NameStr() != "BALL GAME" || info.Type != FileTypeBasic || info.Length != 20 || info.LoadAddress != 0x6006 || info.ExecutionAddress != 0x2020 {
+		t.Errorf("unexpected file info %+v", info)
+	}
+	if back := FBHeaderFromFileInfo(info); back.Name != "BALL GAME" || back.LoadAddress != 0 || back.ExecutionAddress != 0 {
+		t.Errorf("unexpected header from file info %+v", back)
+	}
+}
+
+func TestHeaderFileType(t *testing.T) {
+	for _, tc := range []struct {
+		directive string
+		tp        FBFileType
+		header    string // the type of a program is left out
+	}{
+		{"#type bg-graphics", FileTypeBgGraphics, "#type BG-GRAPHICS\n"},
+		{"#type BASIC", FileTypeBasic, ""},
+	} {
+		program, diags := FBParseProgram(tc.directive+"\n10 END\n", DefaultFBDialect)
+		if len(diags) != 0 {
+			t.Fatal(diags)
+		}
+		info := program.Header.FileInfo(4)
+		if info.Type != tc.tp {
+			t.Errorf("%s: expected type %v, got %v", tc.directive, tc.tp, info.Type)
+		}
+
+		var back FBFileInfo
+		data, err := info.MarshalBinary()
+		if err == nil {
+			err = back.UnmarshalBinary(data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := FBHeaderFromFileInfo(back).String(); h != tc.header {
+			t.Errorf("%s: expected %q, got %q", tc.directive, tc.header, h)
+		}
+	}
+
+	if _, diags := FBParseProgram("#type 0\n#type TEXT\n", DefaultFBDialect); len(diags) != 2 {
+		t.Errorf("expected 2 diagnostics, got %v", diags)
+	}
+}
+
+func TestParseHeaderDiagnostics(t *testing.T) {
+	text := "#name ABCDEFGHIJKLMNOPQ\n#load &HZZ\n#dialect\n10 PRINT\n#title LATE\n#bogus\n"
+	_, diags := FBParseProgram(text, DefaultFBDialect)
+	expected := []string{
+		"1:1: error: name ABCDEFGHIJKLMNOPQ is longer than 16 characters",
+		"2:1: error: invalid address ZZ",
+		"3:1: error: #dialect expects a value",
+		"5:1: error: #title must come before the first line",
+		"6:1: error: unknown directive #bogus",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diags)
+	}
+	for i, d := range diags {
+		if d.Error() != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], d.Error())
+		}
+	}
+}
+
+func TestSourceHeader(t *testing.T) {
+	program, _, diags := FBCompileSource("#name GAME\n#dialect v2.1\n#if v2.1\nERROR\n#endif\n", DialectV3, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	if program.Header.Name != "GAME" || program.Dialect != DialectV2_1 || program.Lines[0].PlainString() != "10 ERROR" {
+		t.Errorf("unexpected program %+v", program)
+	}
+}
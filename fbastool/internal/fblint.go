@@ -0,0 +1,350 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// FBMaxEditorLineLength is the default limit on the length of a line as
+// typed into the screen editor, including its number.
+const FBMaxEditorLineLength = 255
+
+// fbStatement is a statement of a program line, starting with its first
+// token which is not a space.
+type fbStatement struct {
+	line   *FBLine
+	tokens []FBToken
+}
+
+func (s fbStatement) keyword() string {
+	if len(s.tokens) > 0 && s.tokens[0].Kind == TokenKeyword {
+		return s.tokens[0].Keyword()
+	}
+	return ""
+}
+
+// fbStatements splits a line into statements. The statement following
+// THEN is split off too, as it may be an assignment.
+func fbStatements(l *FBLine) []fbStatement {
+	var statements []fbStatement
+	current := fbStatement{line: l}
+	for _, t := range l.Tokens {
+		if t.Kind == TokenRaw && t.Bytes[0] == ':' {
+			statements = append(statements, current)
+			current = fbStatement{line: l}
+		} else if t.Kind != TokenRaw || t.Bytes[0] != ' ' || len(current.tokens) > 0 {
+			current.tokens = append(current.tokens, t)
+			if t.IsKeyword("THEN") {
+				statements = append(statements, current)
+				current = fbStatement{line: l}
+			}
+		}
+	}
+	return append(statements, current)
+}
+
+// fbName is a variable name used in a statement.
+type fbName struct {
+	name  string
+	line  *FBLine
+	token FBToken
+}
+
+// key returns the part of the name significant to the interpreter.
+func (n fbName) key() string {
+	key := strings.TrimSuffix(n.name, "$")
+	if len(key) > 2 {
+		key = key[:2]
+	}
+	if strings.HasSuffix(n.name, "$") {
+		key += "$"
+	}
+	return key
+}
+
+func isFBNameByte(t FBToken, start bool) bool {
+	c := t.Bytes[0]
+	return t.Kind == TokenRaw && ((c >= 'A' && c <= 'Z') || (!start && c >= '0' && c <= '9'))
+}
+
+func (s fbStatement) names() []fbName {
+	var names []fbName
+	if s.keyword() == "DATA" {
+		return nil
+	}
+	for i := 0; i < len(s.tokens); i++ {
+		if !isFBNameByte(s.tokens[i], true) {
+			continue
+		}
+		name := fbName{name: string(s.tokens[i].Bytes[0]), line: s.line, token: s.tokens[i]}
+		for i+1 < len(s.tokens) && isFBNameByte(s.tokens[i+1], false) {
+			i++
+			name.name += string(s.tokens[i].Bytes[0])
+		}
+		if i+1 < len(s.tokens) && s.tokens[i+1].Kind == TokenRaw && s.tokens[i+1].Bytes[0] == '$' {
+			i++
+			name.name += "$"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+type fbLinter struct {
+	program *FBProgram
+	diags   []FBDiagnostic
+	order   []int // index of the line each diagnostic is for
+}
+
+func (p *fbLinter) report(l *FBLine, t *FBToken, severity FBSeverity, kind FBDiagnosticKind, format string, args ...interface{}) {
+	d := FBDiagnostic{
+		Line:     l.Source,
+		Severity: severity,
+		Kind:     kind,
+		Message:  fmt.Sprintf("line %d: ", l.Number) + fmt.Sprintf(format, args...),
+	}
+	if t != nil {
+		d.Column = t.Column
+		d.Text = t.String()
+	}
+	p.diags = append(p.diags, d)
+	for i := range p.program.Lines {
+		if &p.program.Lines[i] == l {
+			p.order = append(p.order, i)
+		}
+	}
+}
+
+func (p *fbLinter) checkTargets() {
+	exists := make(map[int]bool)
+	for _, l := range p.program.Lines {
+		exists[l.Number] = true
+	}
+	for i := range p.program.Lines {
+		l := &p.program.Lines[i]
+		for j := range l.Tokens {
+			if t := &l.Tokens[j]; t.Kind == TokenLineNumber && !exists[t.Value()] {
+				p.report(l, t, SeverityError, DiagMissingTarget, "line %d does not exist", t.Value())
+			}
+		}
+	}
+}
+
+// fallsThrough returns false if running the line never continues with
+// the line after it.
+func fallsThrough(l *FBLine) bool {
+	for _, s := range fbStatements(l) {
+		switch s.keyword() {
+		case "IF":
+			// the rest of the line is conditional
+			return true
+		case "GOTO", "END", "STOP", "RETURN", "RUN", "RESUME":
+			return false
+		}
+	}
+	return true
+}
+
+func (p *fbLinter) checkReachability() {
+	lines := p.program.Lines
+	index := make(map[int]int)
+	for i, l := range lines {
+		if _, ok := index[l.Number]; !ok {
+			index[l.Number] = i
+		}
+	}
+
+	reachable := make([]bool, len(lines))
+	queue := []int{0}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if i >= len(lines) || reachable[i] {
+			continue
+		}
+		reachable[i] = true
+
+		keyword := ""
+		for _, t := range lines[i].Tokens {
+			if t.Kind == TokenKeyword {
+				keyword = t.Keyword()
+			} else if target, ok := index[t.Value()]; ok && t.Kind == TokenLineNumber && keyword != "RESTORE" {
+				queue = append(queue, target)
+			}
+		}
+		if fallsThrough(&lines[i]) {
+			queue = append(queue, i+1)
+		}
+	}
+
+	for i := range lines {
+		if reachable[i] {
+			continue
+		}
+		// DATA and comment lines are never run anyway
+		for _, s := range fbStatements(&lines[i]) {
+			if len(s.tokens) > 0 && s.keyword() != "DATA" && s.tokens[0].Kind != TokenComment {
+				p.report(&lines[i], &s.tokens[0], SeverityWarning, DiagUnreachable, "line can never be reached")
+				break
+			}
+		}
+	}
+}
+
+func (p *fbLinter) checkLoops() {
+	var loops []fbName
+	for i := range p.program.Lines {
+		for _, s := range fbStatements(&p.program.Lines[i]) {
+			names := s.names()
+			switch s.keyword() {
+			case "FOR":
+				if len(names) > 0 {
+					loops = append(loops, names[0])
+				}
+			case "NEXT":
+				if len(names) == 0 && len(loops) > 0 {
+					loops = loops[:len(loops)-1]
+				}
+				for _, n := range names {
+					for j := len(loops) - 1; j >= 0; j-- {
+						if loops[j].key() == n.key() {
+							loops = loops[:j]
+							break
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, loop := range loops {
+		p.report(loop.line, &loop.token, SeverityWarning, DiagForWithoutNext, "FOR %s has no matching NEXT", loop.name)
+	}
+}
+
+func (p *fbLinter) checkNames() {
+	assigned := make(map[string]bool)
+	reported := make(map[string]bool)
+	spellings := make(map[string][]fbName)
+
+	for i := range p.program.Lines {
+		for _, s := range fbStatements(&p.program.Lines[i]) {
+			names := s.names()
+			for _, n := range names {
+				found := false
+				for _, m := range spellings[n.key()] {
+					found = found || m.name == n.name
+				}
+				if !found {
+					spellings[n.key()] = append(spellings[n.key()], n)
+				}
+			}
+
+			// values are assigned after the rest of the statement is run
+			var targets []fbName
+			switch s.keyword() {
+			case "INPUT", "LINPUT", "READ", "DIM":
+				targets, names = names, nil
+			case "FOR":
+				if len(names) > 0 {
+					targets, names = names[:1], names[1:]
+				}
+			case "":
+				if len(names) > 0 && s.tokens[0].Kind == TokenRaw {
+					targets, names = names[:1], names[1:]
+				}
+			}
+			for _, n := range names {
+				if !assigned[n.key()] && !reported[n.key()] {
+					reported[n.key()] = true
+					p.report(n.line, &n.token, SeverityWarning, DiagUseBeforeAssignment, "%s is used before it is assigned a value", n.name)
+				}
+			}
+			for _, n := range targets {
+				assigned[n.key()] = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(spellings))
+	for key := range spellings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		names := spellings[key]
+		for _, n := range names[1:] {
+			p.report(n.line, &n.token, SeverityWarning, DiagNameCollision, "%s is the same variable as %s, as only the first two characters of a name are significant", n.name, names[0].name)
+		}
+	}
+}
+
+func (p *fbLinter) checkTokens(maxLength int) {
+	for i := range p.program.Lines {
+		l := &p.program.Lines[i]
+		for j := range l.Tokens {
+			t := &l.Tokens[j]
+			negative := j > 0 && l.Tokens[j-1].IsKeyword("-")
+			if t.Kind == TokenDecimal && t.Value() > 32767 && !(negative && t.Value() == 32768) {
+				p.report(l, t, SeverityWarning, DiagValueOutOfRange, "%d is outside the 16-bit signed range", t.Value())
+			} else if t.Kind == TokenString && (len(t.Bytes) < 2 || t.Bytes[len(t.Bytes)-1] != '"') {
+				p.report(l, t, SeverityWarning, DiagUnclosedString, "string is never closed")
+			}
+		}
+		if length := utf8.RuneCountInString(l.PlainString()); length > maxLength {
+			p.report(l, nil, SeverityWarning, DiagLineTooLong, "line is %d characters long, the editor accepts at most %d", length, maxLength)
+		}
+	}
+}
+
+func (p *fbLinter) Len() int { return len(p.diags) }
+func (p *fbLinter) Less(i, j int) bool {
+	if p.order[i] != p.order[j] {
+		return p.order[i] < p.order[j]
+	}
+	return p.diags[i].Column < p.diags[j].Column
+}
+func (p *fbLinter) Swap(i, j int) {
+	p.diags[i], p.diags[j] = p.diags[j], p.diags[i]
+	p.order[i], p.order[j] = p.order[j], p.order[i]
+}
+
+// Lint checks the program for common mistakes: jumps to missing lines,
+// unreachable lines, FOR without NEXT, variables used before assignment,
+// names which differ only past the significant first two characters,
+// out of range values, unclosed strings and lines longer than maxLength
+// characters. Lines are checked in the order they are listed.
+func (p *FBProgram) Lint(maxLength int) []FBDiagnostic {
+	linter := fbLinter{program: p}
+	if len(p.Lines) == 0 {
+		return nil
+	}
+	linter.checkTargets()
+	linter.checkReachability()
+	linter.checkLoops()
+	linter.checkNames()
+	linter.checkTokens(maxLength)
+	sort.Stable(&linter)
+	return linter.diags
+}
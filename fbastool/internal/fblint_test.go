@@ -0,0 +1,71 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	text := strings.Join([]string{
+		"10 FOR I=1 TO 10",
+		"20 SPEED=1:PRINT SP",
+		"30 IF X THEN A=1",
+		"40 GOTO 60",
+		"50 PRINT \"NEVER",
+		"55 DATA 1,2,3",
+		"60 B=40000:C=-32768:D=A+1",
+		"70 FOR J=0 TO 3:NEXT J",
+		"80 INPUT \"NAME\";N$:PRINT N$",
+		"90 GOSUB 200",
+		"100 END",
+		"110 " + strings.Repeat("PRINT:", 50),
+	}, "\n")
+	expected := []struct {
+		line int
+		kind FBDiagnosticKind
+	}{
+		{1, DiagForWithoutNext},
+		{2, DiagNameCollision},
+		{3, DiagUseBeforeAssignment},
+		{5, DiagUnreachable},
+		{5, DiagUnclosedString},
+		{7, DiagValueOutOfRange},
+		{10, DiagMissingTarget},
+		{12, DiagLineTooLong},
+		{12, DiagUnreachable},
+	}
+
+	program, diags := FBParseProgram(text, DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	diags = program.Lint(FBMaxEditorLineLength)
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diags), diags)
+	}
+	for i, d := range diags {
+		if d.Line != expected[i].line || d.Kind != expected[i].kind {
+			t.Errorf("diagnostic %d: expected %v on line %d, got %v", i, expected[i].kind, expected[i].line, d)
+		}
+	}
+}
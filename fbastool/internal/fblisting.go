@@ -0,0 +1,243 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// FBScreenCell is one character cell of the screen. Text is set instead of
+// Char for text which has no Family BASIC character.
+type FBScreenCell struct {
+	Char byte
+	Text string
+}
+
+// IsGraphic returns true for the graphic characters, written \A0 to \M7.
+func (c FBScreenCell) IsGraphic() bool {
+	return c.Text == "" && (c.Char < 0x20 || c.Char >= 0xB7)
+}
+
+func (c FBScreenCell) String() string {
+	if c.Text != "" {
+		return c.Text
+	}
+	return FBByteToString(c.Char)
+}
+
+// FBScreenCells splits text into the character cells it takes up on
+// screen.
+func FBScreenCells(s string) []FBScreenCell {
+	var cells []FBScreenCell
+	for len(s) > 0 {
+		v, rest := fbStringToBytesPrefix(s)
+		for _, c := range v {
+			cells = append(cells, FBScreenCell{Char: c})
+		}
+		if rest != "" {
+			_, size := utf8.DecodeRuneInString(rest)
+			cells = append(cells, FBScreenCell{Text: rest[:size]})
+			rest = rest[size:]
+		}
+		s = rest
+	}
+	return cells
+}
+
+// FBScreenRows wraps cells into rows the way the screen does.
+func FBScreenRows(cells []FBScreenCell) [][]FBScreenCell {
+	var rows [][]FBScreenCell
+	for len(cells) > FBNameTableWidth {
+		rows = append(rows, cells[:FBNameTableWidth])
+		cells = cells[FBNameTableWidth:]
+	}
+	return append(rows, cells)
+}
+
+// FBFont is a character set image: 16 by 16 characters of 8 by 8 pixels,
+// in character code order.
+type FBFont struct {
+	image  image.Image
+	glyphs map[byte]string
+}
+
+func ReadFBFont(r io.Reader) (*FBFont, error) {
+	img, err := png.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if img.Bounds().Dx() < 128 || img.Bounds().Dy() < 128 {
+		return nil, fmt.Errorf("font must be at least 128x128 pixels, is %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+	return &FBFont{image: img, glyphs: make(map[byte]string)}, nil
+}
+
+// glyph returns the character as a PNG data URI.
+func (f *FBFont) glyph(c byte) (string, error) {
+	if uri, ok := f.glyphs[c]; ok {
+		return uri, nil
+	}
+	min := f.image.Bounds().Min
+	tile := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			tile.Set(x, y, f.image.At(min.X+int(c&15)*8+x, min.Y+int(c>>4)*8+y))
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, tile); err != nil {
+		return "", err
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+	f.glyphs[c] = uri
+	return uri, nil
+}
+
+type FBListingOptions struct {
+	Title       string
+	RowsPerPage int
+	Checksum    FBLineChecksum // nil for no checksums
+	// Font draws the graphic characters. No character set is built in, as
+	// the machine's own is not ours to ship; without one, graphic
+	// characters are shown as their escapes, and a note on the last page
+	// says so.
+	Font *FBFont
+}
+
+type fbListingLine struct {
+	Checksum string
+	Rows     [][]FBScreenCell
+}
+
+type fbListingPage struct {
+	Number int
+	Lines  []fbListingLine
+}
+
+// WriteListingHTML writes the program as a type-in listing: wrapped as on
+// screen and split into pages, never breaking a line across pages.
+func (p *FBProgram) WriteListingHTML(w io.Writer, opts FBListingOptions) error {
+	var pages []fbListingPage
+	var page fbListingPage
+	rows := 0
+	for _, l := range p.Lines {
+		line := fbListingLine{Rows: FBScreenRows(FBScreenCells(l.PlainString()))}
+		if opts.Checksum != nil {
+			line.Checksum = l.Checksum(opts.Checksum)
+		}
+		if rows > 0 && rows+len(line.Rows) > opts.RowsPerPage {
+			pages = append(pages, page)
+			page = fbListingPage{}
+			rows = 0
+		}
+		page.Lines = append(page.Lines, line)
+		rows += len(line.Rows)
+	}
+	if len(page.Lines) > 0 || len(pages) == 0 {
+		pages = append(pages, page)
+	}
+	for i := range pages {
+		pages[i].Number = i + 1
+	}
+
+	escapes := false
+	for _, l := range p.Lines {
+		for _, c := range FBScreenCells(l.PlainString()) {
+			escapes = escapes || (opts.Font == nil && c.IsGraphic())
+		}
+	}
+
+	var cellErr error
+	tmpl, err := template.New("listing").Funcs(template.FuncMap{
+		"cell": func(c FBScreenCell) template.HTML {
+			if c.IsGraphic() {
+				if opts.Font == nil {
+					return template.HTML(`<span class="g">` + template.HTMLEscapeString(c.String()[1:]) + `</span>`)
+				}
+				uri, err := opts.Font.glyph(c.Char)
+				if err != nil {
+					cellErr = err
+				}
+				return template.HTML(`<span><img src="` + uri + `" alt="` + template.HTMLEscapeString(c.String()) + `"></span>`)
+			}
+			if c.Text == "" && c.Char == ' ' {
+				return "<span>&nbsp;</span>"
+			}
+			return template.HTML("<span>" + template.HTMLEscapeString(c.String()) + "</span>")
+		},
+		"checksums": func() bool { return opts.Checksum != nil },
+	}).Parse(fbListingTemplate)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	err = tmpl.Execute(&buf, struct {
+		Title   string
+		Pages   []fbListingPage
+		Escapes bool
+	}{opts.Title, pages, escapes})
+	if err == nil {
+		err = cellErr
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, buf.String())
+	return err
+}
+
+const fbListingTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: monospace; font-size: 11pt; }
+.page { break-after: page; margin-bottom: 2em; }
+.page:last-child { break-after: auto; }
+table { border-collapse: collapse; }
+td { padding: 0 4px; vertical-align: top; white-space: nowrap; }
+.sum { font-weight: bold; border-right: 1px solid #999; }
+.row span { display: inline-block; width: 1.2em; height: 1.2em; text-align: center; overflow: hidden; }
+.row img { width: 1em; height: 1em; image-rendering: pixelated; }
+.row .g { font-size: 50%; outline: 1px solid #999; }
+.footer { margin-top: 1em; font-family: sans-serif; font-size: 9pt; }
+</style>
+</head>
+<body>
+{{range .Pages}}<div class="page">
+<table>
+{{range .Lines}}{{$sum := .Checksum}}{{range $i, $row := .Rows}}<tr>{{if checksums}}<td class="sum">{{if eq $i 0}}{{$sum}}{{end}}</td>{{end}}<td class="row">{{range $row}}{{cell .}}{{end}}</td></tr>
+{{end}}{{end}}</table>
+{{if and $.Escapes (eq .Number (len $.Pages))}}<div class="footer">Boxed codes are graphic characters, written as escapes: <span class="row"><span class="g">A0</span></span> is \A0.</div>
+{{end}}<div class="footer">{{$.Title}} - {{.Number}}/{{len $.Pages}}</div>
+</div>
+{{end}}</body>
+</html>
+`
@@ -0,0 +1,88 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestScreenRows(t *testing.T) {
+	cells := FBScreenCells("10 PRINT \"\\A0アイ\":REM " + strings.Repeat("X", 20))
+	if len(cells) != 39 {
+		t.Fatalf("expected 39 cells, got %d", len(cells))
+	}
+	if !cells[10].IsGraphic() || cells[10].String() != "\\A0" || cells[11].IsGraphic() {
+		t.Errorf("unexpected cells %v", cells[10:12])
+	}
+	rows := FBScreenRows(cells)
+	if len(rows) != 2 || len(rows[0]) != FBNameTableWidth || len(rows[1]) != 11 {
+		t.Errorf("unexpected rows %v", rows)
+	}
+}
+
+func TestListingHTML(t *testing.T) {
+	program, diags := FBParseProgram("10 PRINT \"\\A0\":REM "+strings.Repeat("X", 30)+"\n20 GOTO 10\n30 END\n", DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+
+	var buf bytes.Buffer
+	if err := program.WriteListingHTML(&buf, FBListingOptions{Title: "TEST", RowsPerPage: 3, Checksum: FBLineChecksums["fb8"]}); err != nil {
+		t.Fatal(err)
+	}
+	html := buf.String()
+	// the second line fits on the first page, the third does not
+	if strings.Count(html, `<div class="page">`) != 2 || !strings.Contains(html, "TEST - 2/2") {
+		t.Errorf("expected two pages:\n%s", html)
+	}
+	if !strings.Contains(html, `<td class="sum">`+program.Lines[1].Checksum(FBLineChecksums["fb8"])+`</td>`) {
+		t.Errorf("missing checksum:\n%s", html)
+	}
+	if !strings.Contains(html, `<span class="g">A0</span>`) {
+		t.Errorf("missing graphic character:\n%s", html)
+	}
+	if strings.Count(html, "Boxed codes are graphic characters") != 1 {
+		t.Errorf("expected one note on graphic characters:\n%s", html)
+	}
+
+	var font bytes.Buffer
+	if err := png.Encode(&font, image.NewGray(image.Rect(0, 0, 128, 128))); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ReadFBFont(&font)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := program.WriteListingHTML(&buf, FBListingOptions{RowsPerPage: 60, Font: f}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `<img src="data:image/png;base64,`) {
+		t.Errorf("graphic character not drawn from the font:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "Boxed codes") {
+		t.Errorf("note on escapes shown with a font:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"sort"
+)
+
+// FBMemoryBudget is the program area free on a freshly started machine.
+type FBMemoryBudget struct {
+	Dialect FBDialect
+	Mode    string
+	Free    int
+}
+
+// fbBGReserve estimates the memory taken by a BG GRAPHIC screen kept
+// alongside the program as one byte per character cell. It has not been
+// measured on the machine.
+const fbBGReserve = FBNameTableWidth * FBNameTableHeight
+
+// FBMemoryBudgets lists the free program area of each version, with and
+// without a BG GRAPHIC screen in memory. These are estimates, not checked
+// against a manual or a machine; size --free takes the figure a machine
+// actually reports.
+var FBMemoryBudgets = []FBMemoryBudget{
+	{DialectV1, "GAME BASIC", 1982},
+	{DialectV1, "BG GRAPHIC", 1982 - fbBGReserve},
+	{DialectV2_0, "GAME BASIC", 1982},
+	{DialectV2_0, "BG GRAPHIC", 1982 - fbBGReserve},
+	{DialectV2_1, "GAME BASIC", 1982},
+	{DialectV2_1, "BG GRAPHIC", 1982 - fbBGReserve},
+	{DialectV3, "GAME BASIC", 4086},
+	{DialectV3, "BG GRAPHIC", 4086 - fbBGReserve},
+}
+
+// FBLineSize is the memory taken by one line of a program.
+type FBLineSize struct {
+	Number int
+	Size   int
+}
+
+// LargestLines returns the count largest lines, largest first.
+func (p *FBProgram) LargestLines(count int) []FBLineSize {
+	sizes := make([]FBLineSize, len(p.Lines))
+	for i, l := range p.Lines {
+		sizes[i] = FBLineSize{Number: l.Number, Size: 3 + len(l.Bytes())}
+	}
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].Size > sizes[j].Size
+	})
+	if len(sizes) > count {
+		sizes = sizes[:count]
+	}
+	return sizes
+}
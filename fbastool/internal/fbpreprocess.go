@@ -0,0 +1,280 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+func isFBIdentifierStart(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || c == '_'
+}
+
+func isFBIdentifier(s string) bool {
+	if s == "" || !isFBIdentifierStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isFBLabelByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// fbCommentStart returns the position of the // comment in a line of
+// source, or -1 if it has none. // is text in strings, REM and '.
+func fbCommentStart(s string) int {
+	inString, data := false, false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			inString = !inString
+		}
+		if inString {
+			continue
+		}
+		if strings.HasPrefix(s[i:], "//") {
+			return i
+		} else if s[i] == ':' {
+			data = false
+		} else if data {
+			continue
+		} else if s[i] == '\'' || strings.HasPrefix(s[i:], "REM") {
+			return -1
+		} else if strings.HasPrefix(s[i:], "DATA") {
+			data = true
+		}
+	}
+	return -1
+}
+
+// stripComment leaves out the // comment of a line, or turns it into a
+// REM if it starts with //! and comments are kept.
+func (c *fbCompiler) stripComment(t fbSourceText) fbSourceText {
+	i := fbCommentStart(t.text)
+	if i < 0 {
+		return t
+	}
+	code := t.slice(0, len(strings.TrimRight(t.text[:i], " \t")))
+	if !c.opts.KeepComments || !strings.HasPrefix(t.text[i:], "//!") {
+		return code
+	}
+	rem := "REM"
+	if strings.TrimSpace(code.text) != "" {
+		rem = ":REM"
+	}
+	text := t.slice(i+3, len(t.text)).trimLeft()
+	if text.text != "" {
+		rem += " "
+	}
+	text.text = FBUpperCase(text.text)
+	return code.append(rem, t.column(i)).appendText(text)
+}
+
+// fbCondition is an #if block being preprocessed.
+type fbCondition struct {
+	start  fbSourceText
+	parent bool // whether the text around the block is kept
+	taken  bool // whether a branch of the block has been kept
+	active bool
+	inElse bool
+}
+
+// preprocess runs the directives of a source file, returning the lines
+// which are kept, with defined names replaced and // comments left out.
+func (c *fbCompiler) preprocess(filename string, s string) []fbSourceText {
+	c.files = append(c.files, filename)
+	defer func() { c.files = c.files[:len(c.files)-1] }()
+
+	var lines []fbSourceText
+	var conditions []*fbCondition
+	active := func() bool {
+		return len(conditions) == 0 || conditions[len(conditions)-1].active
+	}
+	for _, t := range fbSourceTexts(filename, s) {
+		t = c.stripComment(t)
+		line := t.trimLeft()
+		if !strings.HasPrefix(line.text, "#") {
+			if active() {
+				lines = append(lines, c.substitute(t))
+				c.started = c.started || line.text != ""
+			}
+			continue
+		}
+
+		name := strings.Fields(line.text)[0]
+		args := strings.TrimSpace(line.text[len(name):])
+		switch strings.ToLower(name) {
+		case "#if", "#ifdef", "#ifndef":
+			cond := &fbCondition{start: line, parent: active()}
+			if cond.parent {
+				cond.active = c.evaluate(line, strings.ToLower(name), args)
+				cond.taken = cond.active
+			}
+			conditions = append(conditions, cond)
+		case "#else":
+			if len(conditions) == 0 || conditions[len(conditions)-1].inElse {
+				c.report(line, 0, name, SeverityError, DiagDirective, "#else without #if")
+				continue
+			}
+			cond := conditions[len(conditions)-1]
+			cond.active = cond.parent && !cond.taken
+			cond.inElse = true
+		case "#endif":
+			if len(conditions) == 0 {
+				c.report(line, 0, name, SeverityError, DiagDirective, "#endif without #if")
+				continue
+			}
+			conditions = conditions[:len(conditions)-1]
+		default:
+			if active() {
+				lines = append(lines, c.directive(line, strings.ToLower(name), args)...)
+			}
+		}
+	}
+	for _, cond := range conditions {
+		c.report(cond.start, 0, "", SeverityError, DiagDirective, "%s without #endif", strings.Fields(cond.start.text)[0])
+	}
+	return lines
+}
+
+// evaluate returns whether the lines following an #if, #ifdef or #ifndef
+// are kept. #if is followed by the dialects the lines are for.
+func (c *fbCompiler) evaluate(line fbSourceText, name string, args string) bool {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || (name != "#if" && len(fields) != 1) {
+		c.report(line, 0, "", SeverityError, DiagDirective, "%s expects %s", name, map[string]string{"#if": "dialects", "#ifdef": "a name", "#ifndef": "a name"}[name])
+		return false
+	}
+	switch name {
+	case "#ifdef":
+		_, ok := c.defines[fields[0]]
+		return ok
+	case "#ifndef":
+		_, ok := c.defines[fields[0]]
+		return !ok
+	}
+	result := false
+	for _, f := range fields {
+		dialect, err := ParseFBDialect(f)
+		if err != nil {
+			c.report(line, strings.Index(line.text, f), f, SeverityError, DiagDirective, "%v", err)
+		}
+		result = result || (err == nil && dialect == c.dialect)
+	}
+	return result
+}
+
+// directive runs a directive other than a conditional, returning any lines
+// it adds.
+func (c *fbCompiler) directive(line fbSourceText, name string, args string) []fbSourceText {
+	switch name {
+	case "#include":
+		return c.include(line, strings.Trim(args, "\"<>"))
+	case "#define":
+		fields := strings.Fields(args)
+		if len(fields) == 0 || !isFBIdentifier(fields[0]) {
+			c.report(line, 0, "", SeverityError, DiagDirective, "#define expects a name, followed by its value")
+			return nil
+		}
+		value := c.substitute(newFBSourceText(line.location, strings.TrimSpace(args[len(fields[0]):]))).text
+		if old, ok := c.defines[fields[0]]; ok && old != value {
+			c.report(line, 0, fields[0], SeverityWarning, DiagDirective, "%s is redefined", fields[0])
+		}
+		c.defines[fields[0]] = value
+	case "#undef":
+		delete(c.defines, args)
+	case "#name", "#title", "#dialect", "#type", "#load", "#exec":
+		if c.started {
+			c.report(line, 0, name, SeverityError, DiagDirective, "%s must come before the first line", name)
+		} else if err := c.header.set(name, args); err != nil {
+			c.report(line, 0, name, SeverityError, DiagDirective, "%v", err)
+		} else if c.header.HasDialect {
+			c.dialect = c.header.Dialect
+		}
+	default:
+		c.report(line, 0, name, SeverityError, DiagDirective, "unknown directive %s", name)
+	}
+	return nil
+}
+
+func (c *fbCompiler) include(line fbSourceText, filename string) []fbSourceText {
+	if filename == "" {
+		c.report(line, 0, "", SeverityError, DiagDirective, "#include expects a file name")
+		return nil
+	}
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(filepath.Dir(c.files[len(c.files)-1]), filename)
+	}
+	for _, f := range c.files {
+		if f == filename {
+			c.report(line, 0, "", SeverityError, DiagDirective, "%s includes itself", filename)
+			return nil
+		}
+	}
+	if c.opts.Include == nil {
+		c.report(line, 0, "", SeverityError, DiagDirective, "cannot include %s", filename)
+		return nil
+	}
+	text, err := c.opts.Include(filename)
+	if err != nil {
+		c.report(line, 0, "", SeverityError, DiagDirective, "cannot include %s: %v", filename, err)
+		return nil
+	}
+	return c.preprocess(filename, text)
+}
+
+// substitute replaces defined names with their values, outside of
+// strings and comments.
+func (c *fbCompiler) substitute(t fbSourceText) fbSourceText {
+	if len(c.defines) == 0 {
+		return t
+	}
+	result := t.slice(0, 0)
+	start := 0
+	inString := false
+	for i := 0; i < len(t.text); i++ {
+		s := t.text[i:]
+		if s[0] == '"' {
+			inString = !inString
+		}
+		if inString {
+			continue
+		}
+		if s[0] == '\'' || strings.HasPrefix(s, "REM") {
+			break
+		}
+		if !isFBIdentifierStart(s[0]) || (i > 0 && (isFBLabelByte(t.text[i-1]) || t.text[i-1] == '@')) {
+			continue
+		}
+		n := 1
+		for n < len(s) && isFBLabelByte(s[n]) {
+			n++
+		}
+		if value, ok := c.defines[s[:n]]; ok {
+			result = result.appendText(t.slice(start, i)).append(value, t.column(i))
+			start = i + n
+		}
+		i += n - 1
+	}
+	return result.appendText(t.slice(start, len(t.text)))
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPreprocess(t *testing.T) {
+	files := map[string]string{
+		"lib/sound.bas":  "#define NOTE \"O4C\"\n#include \"common.bas\"\n@beep: PLAY NOTE:RETURN\n",
+		"lib/common.bas": "#ifndef COMMON\n#define COMMON\n1000 REM COMMON\n#endif\n",
+	}
+	include := func(filename string) (string, error) {
+		if s, ok := files[filename]; ok {
+			return s, nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+	source := "#define SPEED 3\n#include \"lib/sound.bas\"\n#include \"lib/common.bas\"\n" +
+		"#if v3\nX=X+SPEED:PRINT \"SPEED\"\n#else\nX=X+1\n#endif\n" +
+		"#if v1 v2.0 v2.1\nERROR\n#endif\nGOSUB @beep\n"
+
+	program, sourceMap, diags := FBCompileSource(source, DialectV3, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10, Include: include})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "10 PLAY \"O4C\":RETURN\n20 X=X+3:PRINT \"SPEED\"\n30 GOSUB 10\n1000 REM COMMON\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	if sourceMap[0].Location != (FBSourceLocation{"lib/sound.bas", 3}) || sourceMap[3].Location != (FBSourceLocation{"lib/common.bas", 3}) {
+		t.Errorf("unexpected source map\n%s", sourceMap)
+	}
+
+	program, _, _ = FBCompileSource(source, DialectV2_1, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10, Include: include})
+	if program.Lines[1].PlainString() != "20 X=X+1" {
+		t.Errorf("expected the #else branch, got %s", program.Lines[1].PlainString())
+	}
+}
+
+func TestPreprocessProgram(t *testing.T) {
+	include := func(filename string) (string, error) {
+		if filename == "lib.bas" {
+			return "1000 RETURN\n1010 GOTO\n", nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+	source := "#name DEMO\n#include \"lib.bas\"\n#define N 5\n#if v3\n10 PRINT N // five\n#else\n10 PRINT 1\n#endif\n20 GOSUB 1000\n"
+
+	program, diags := FBPreprocessProgram(source, DialectV3, FBSourceOptions{Filename: "main.bas", Include: include})
+	expected := "#name DEMO\n10 PRINT 5\n20 GOSUB 1000\n1000 RETURN\n1010 GOTO\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	if len(diags) != 0 {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+
+	program, _ = FBPreprocessProgram(source, DialectV2_1, FBSourceOptions{Filename: "main.bas", Include: include})
+	if program.Lines[0].PlainString() != "10 PRINT 1" {
+		t.Errorf("expected the #else branch, got %s", program.Lines[0].PlainString())
+	}
+
+	// included lines must not collide with the listing's, which may repeat
+	// their own numbers
+	files := map[string]string{"lib/snd.bas": "1000 BEEP\n1010 RETURN\n10 END\n"}
+	include = func(filename string) (string, error) {
+		return files[filename], nil
+	}
+	program, diags = FBPreprocessProgram("#include \"lib/snd.bas\"\n10 PRINT\n20 GOSUB 1000\n20 GOSUB 1010\n", DialectV3, FBSourceOptions{Filename: "p.txt", Include: include})
+	if len(diags) != 1 || diags[0].Kind != DiagLineCollision || diags[0].File != "p.txt" || diags[0].Line != 2 {
+		t.Errorf("expected a collision on line 2 of p.txt, got %v", diags)
+	}
+	if numbers := program.String(); numbers != "10 END\n10 PRINT\n20 GOSUB 1000\n20 GOSUB 1010\n1000 BEEP\n1010 RETURN\n" {
+		t.Errorf("unexpected lines\n%s", numbers)
+	}
+
+	// lines need numbers, and labels are not read
+	_, diags = FBPreprocessProgram("@a: PRINT\n10 GOTO @a\n", DialectV3, FBSourceOptions{Filename: "main.bas"})
+	if len(diags) != 2 || diags[0].Kind != DiagMissingLineNumber || diags[1].Kind != DiagInvalidCharacter {
+		t.Errorf("unexpected diagnostics %v", diags)
+	}
+}
+
+func TestPreprocessDiagnostics(t *testing.T) {
+	files := map[string]string{
+		"a.bas": "100 PRINT \"A\"\n#include \"main.bas\"\n",
+		"b.bas": "100 PRINT \"B\"\n#bogus\n",
+	}
+	include := func(filename string) (string, error) {
+		return files[filename], nil
+	}
+	source := "#include \"a.bas\"\n#include \"b.bas\"\n#if v4\n#else\n#else\n#ifdef\n"
+	_, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10, Include: include})
+	expected := []string{
+		"main.bas:3:5: error: unknown dialect v4 (expected v1, v2.0, v2.1 or v3)",
+		"main.bas:3:1: error: #if without #endif",
+		"main.bas:5:1: error: #else without #if",
+		"main.bas:6:1: error: #ifdef expects a name",
+		"main.bas:6:1: error: #ifdef without #endif",
+		"a.bas:2:1: error: main.bas includes itself",
+		"b.bas:1:1: error: line 100 is also used at a.bas:1",
+		"b.bas:2:1: error: unknown directive #bogus",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diags)
+	}
+	for i, e := range expected {
+		if s := diags[i].Format("main.bas"); s != e {
+			t.Errorf("diagnostic %d: expected %s, got %s", i, e, s)
+		}
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	source := "// demo\n#define N 3 // count\n@top: // main loop\n  PRINT N // show\n  //! about here\n  DATA A,B // items\n  GOTO @top //! again\n"
+	program, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "10 PRINT 3\n20 DATA A,B\n30 GOTO 10\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+
+	program, _, _ = FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "main.bas", Start: 10, Step: 10, KeepComments: true})
+	expected = "10 PRINT 3\n20 REM ABOUT HERE\n30 DATA A,B\n40 GOTO 10:REM AGAIN\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+}
@@ -0,0 +1,877 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type FBTokenKind uint8
+
+const (
+	TokenRaw        FBTokenKind = iota // a single character, stored as-is
+	TokenKeyword                       // a keyword or operator
+	TokenLineNumber                    // 0x0B: a line number reference
+	TokenDecimal                       // 0x12: a decimal constant
+	TokenHex                           // 0x11: a hexadecimal constant
+	TokenShort                         // 0x01 - 0x0A: a constant from 0 to 9
+	TokenString                        // a string literal, including its quotes
+	TokenComment                       // REM or ', followed by the rest of the line
+	TokenUnknown                       // a single byte with no known meaning
+)
+
+// FBToken is a token of a program line. Bytes holds its encoded form, as
+// stored in the program; Column is its 1-based position in the source text,
+// or 0 if the token was not read from text.
+type FBToken struct {
+	Kind   FBTokenKind
+	Bytes  []byte
+	Column int
+}
+
+type FBLine struct {
+	Number int
+	Tokens []FBToken
+	Source int // 1-based line in the source text, or 0
+	// Comment is a // comment at the end of the line, and CommentLines the
+	// // comment lines before it; neither is part of the program.
+	Comment      string
+	CommentLines []string
+}
+
+type FBProgram struct {
+	Dialect      FBDialect
+	Header       FBHeader
+	Lines        []FBLine
+	CommentLines []string // // comment lines after the last line
+}
+
+func (k FBTokenKind) String() string {
+	switch k {
+	case TokenRaw:
+		return "raw"
+	case TokenKeyword:
+		return "keyword"
+	case TokenLineNumber:
+		return "line-number"
+	case TokenDecimal:
+		return "decimal"
+	case TokenHex:
+		return "hex"
+	case TokenShort:
+		return "short"
+	case TokenString:
+		return "string"
+	case TokenComment:
+		return "comment"
+	case TokenUnknown:
+		return "unknown-byte"
+	default:
+		return "unknown"
+	}
+}
+
+// NewFBNumberToken returns a numeric token of the given kind.
+func NewFBNumberToken(kind FBTokenKind, v int) FBToken {
+	switch kind {
+	case TokenLineNumber:
+		return FBToken{Kind: kind, Bytes: []byte{0x0B, byte(v & 0xFF), byte((v >> 8) & 0xFF)}}
+	case TokenDecimal:
+		return FBToken{Kind: kind, Bytes: []byte{0x12, byte(v & 0xFF), byte((v >> 8) & 0xFF)}}
+	case TokenHex:
+		return FBToken{Kind: kind, Bytes: []byte{0x11, byte(v & 0xFF), byte((v >> 8) & 0xFF)}}
+	case TokenShort:
+		return FBToken{Kind: kind, Bytes: []byte{byte(v + 1)}}
+	default:
+		panic(fmt.Errorf("not a numeric token kind: %v", kind))
+	}
+}
+
+func (t FBToken) IsNumber() bool {
+	return t.Kind == TokenLineNumber || t.Kind == TokenDecimal || t.Kind == TokenHex || t.Kind == TokenShort
+}
+
+// Value returns the value of a numeric token, or the byte of any other
+// single-byte token.
+func (t FBToken) Value() int {
+	if t.Kind == TokenShort {
+		return int(t.Bytes[0]) - 1
+	} else if len(t.Bytes) == 3 && t.IsNumber() {
+		return int(t.Bytes[1]) | (int(t.Bytes[2]) << 8)
+	} else {
+		return int(t.Bytes[0])
+	}
+}
+
+// Keyword returns the keyword of a keyword or REM comment token.
+func (t FBToken) Keyword() string {
+	if t.Kind == TokenKeyword || (t.Kind == TokenComment && t.Bytes[0] != '\'') {
+		return idToKeywordMap[t.Bytes[0]]
+	}
+	return ""
+}
+
+func (t FBToken) IsKeyword(keyword string) bool {
+	return t.Kind == TokenKeyword && idToKeywordMap[t.Bytes[0]] == keyword
+}
+
+func (t FBToken) String() string {
+	switch t.Kind {
+	case TokenKeyword:
+		return idToKeywordMap[t.Bytes[0]]
+	case TokenLineNumber, TokenDecimal, TokenShort:
+		return strconv.Itoa(t.Value())
+	case TokenHex:
+		return fmt.Sprintf("&H%X", t.Value())
+	case TokenComment:
+		var s strings.Builder
+		if t.Bytes[0] == '\'' {
+			s.WriteString("'")
+		} else {
+			s.WriteString(idToKeywordMap[t.Bytes[0]])
+		}
+		for _, c := range t.Bytes[1:] {
+			s.WriteString(FBByteToString(c))
+		}
+		return s.String()
+	case TokenUnknown:
+		return t.escaped()
+	default:
+		var s strings.Builder
+		for _, c := range t.Bytes {
+			s.WriteString(FBByteToString(c))
+		}
+		return s.String()
+	}
+}
+
+// escaped returns the token as a sequence of {$HH} escapes.
+func (t FBToken) escaped() string {
+	var s strings.Builder
+	for _, c := range t.Bytes {
+		fmt.Fprintf(&s, "{$%02X}", c)
+	}
+	return s.String()
+}
+
+// annotated returns the token's text, with an increasingly explicit
+// annotation of its encoding as level grows; false is returned if no
+// further level exists.
+func (t FBToken) annotated(level int) (string, bool) {
+	if level == 0 {
+		return t.String(), true
+	}
+	switch t.Kind {
+	case TokenString, TokenComment:
+		return t.escaped(), level == 1
+	case TokenKeyword:
+		if level == 1 {
+			return "{" + t.String() + "}", true
+		}
+	case TokenLineNumber, TokenDecimal, TokenShort:
+		if level == 1 {
+			for name, kind := range fbNumberEscapes {
+				if kind == t.Kind {
+					return fmt.Sprintf("{%s}%d", name, t.Value()), true
+				}
+			}
+		}
+	default:
+		if level == 1 {
+			return t.String() + "{}", true
+		}
+	}
+	return t.escaped(), level == 2
+}
+
+func (l FBLine) format(levels []int) string {
+	var s strings.Builder
+	s.WriteString(strconv.Itoa(l.Number))
+	s.WriteString(" ")
+	if len(l.Tokens) == 0 {
+		s.WriteString("{}")
+	}
+	for i, t := range l.Tokens {
+		text, _ := t.annotated(levels[i])
+		s.WriteString(text)
+	}
+	if l.Comment != "" {
+		s.WriteString(" ")
+		s.WriteString(l.Comment)
+	}
+	return s.String()
+}
+
+func (l FBLine) String() string {
+	return l.Text(DefaultFBDialect)
+}
+
+// Text returns the line as text which tokenizes back to the same bytes in
+// the given dialect. Tokens are annotated with their encoding only where
+// the plain text would not reproduce it.
+func (l FBLine) Text(dialect FBDialect) string {
+	expected := l.Bytes()
+	levels := make([]int, len(l.Tokens))
+	if len(l.Tokens) == 0 {
+		return l.format(levels)
+	}
+	for {
+		text := l.format(levels)
+		p := fbLineParser{line: text, dialect: dialect}
+		parsed, ok := p.parse()
+		var actual []byte
+		if ok && parsed.Number == l.Number {
+			actual = parsed.Bytes()
+			if bytes.Equal(actual, expected) {
+				return text
+			}
+		}
+
+		// annotate the token at the first difference, or failing that, the
+		// nearest token before it
+		mismatch := 0
+		for mismatch < len(actual) && mismatch < len(expected) && actual[mismatch] == expected[mismatch] {
+			mismatch++
+		}
+		i := 0
+		for pos := len(l.Tokens[0].Bytes); i < len(l.Tokens)-1 && pos <= mismatch; pos += len(l.Tokens[i].Bytes) {
+			i++
+		}
+		for ; i >= 0; i-- {
+			if _, ok := l.Tokens[i].annotated(levels[i] + 1); ok {
+				levels[i]++
+				break
+			}
+		}
+		if i < 0 {
+			return text
+		}
+	}
+}
+
+// PlainString returns the line as text, without any annotations.
+func (l FBLine) PlainString() string {
+	var s strings.Builder
+	s.WriteString(strconv.Itoa(l.Number))
+	s.WriteString(" ")
+	for _, t := range l.Tokens {
+		s.WriteString(t.String())
+	}
+	return s.String()
+}
+
+// Bytes returns the line's tokens in their stored form, including the
+// terminating zero byte but not the line header.
+func (l FBLine) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, t := range l.Tokens {
+		buf.Write(t.Bytes)
+	}
+	buf.WriteByte(0x00)
+	return buf.Bytes()
+}
+
+func (p *FBProgram) String() string {
+	var s strings.Builder
+	s.WriteString(p.Header.String())
+	for _, l := range p.Lines {
+		for _, c := range l.CommentLines {
+			s.WriteString(c)
+			s.WriteString("\n")
+		}
+		s.WriteString(l.Text(p.Dialect))
+		s.WriteString("\n")
+	}
+	for _, c := range p.CommentLines {
+		s.WriteString(c)
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+func (p *FBProgram) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	for _, l := range p.Lines {
+		lineData := l.Bytes()
+		if len(lineData) >= 253 {
+			return nil, fmt.Errorf("line %d too long", l.Number)
+		}
+		buf.Write([]byte{byte(len(lineData) + 3), byte(l.Number & 0xFF), byte((l.Number >> 8) & 0xFF)})
+		buf.Write(lineData)
+	}
+	buf.WriteByte(0x00)
+	return buf.Bytes(), nil
+}
+
+// FindLine returns the index of the line with the given number, or -1.
+func (p *FBProgram) FindLine(number int) int {
+	for i, l := range p.Lines {
+		if l.Number == number {
+			return i
+		}
+	}
+	return -1
+}
+
+// FBReadProgram reads a tokenized program. Bytes with no known meaning in
+// the given dialect are kept as unknown tokens; a line whose length does
+// not fit the data is an error.
+func FBReadProgram(reader io.Reader, dialect FBDialect) (*FBProgram, error) {
+	program := &FBProgram{Dialect: dialect}
+	header := make([]byte, 3)
+
+	for {
+		_, err := io.ReadFull(reader, header[:1])
+		if err != nil || header[0] == 0 {
+			break
+		}
+		_, err = io.ReadFull(reader, header[1:3])
+		if err != nil {
+			return nil, errors.New("program ends inside a line header")
+		}
+		line := FBLine{Number: int(header[1]) | (int(header[2]) << 8)}
+		if header[0] < 3 {
+			return nil, fmt.Errorf("line %d has invalid length %d", line.Number, header[0])
+		}
+
+		lineData := make([]byte, int(header[0])-3)
+		n, _ := io.ReadFull(reader, lineData)
+		if n < len(lineData) {
+			return nil, fmt.Errorf("line %d is truncated: %d of %d bytes present", line.Number, n, len(lineData))
+		}
+		if n > 0 && lineData[n-1] == 0x00 {
+			lineData = lineData[:n-1]
+		}
+
+		line.Tokens = fbReadLineTokens(lineData, dialect)
+		program.Lines = append(program.Lines, line)
+	}
+
+	return program, nil
+}
+
+func fbReadLineTokens(lineData []byte, dialect FBDialect) []FBToken {
+	var tokens []FBToken
+	parsingData := false
+
+	for i := 0; i < len(lineData); i++ {
+		id := lineData[i]
+		if parsingData && id != 0x00 && id != '"' && id != ':' {
+			tokens = append(tokens, FBToken{Kind: TokenRaw, Bytes: lineData[i : i+1]})
+		} else if id == 0x95 || id == '\'' {
+			// REM and ' act as comments
+			tokens = append(tokens, FBToken{Kind: TokenComment, Bytes: lineData[i:]})
+			break
+		} else if dialect.HasKeyword(id) {
+			tokens = append(tokens, FBToken{Kind: TokenKeyword, Bytes: lineData[i : i+1]})
+			if id == 0x91 {
+				// DATA is followed by unprocessed text
+				parsingData = true
+			}
+		} else if id == '"' {
+			end := bytes.IndexByte(lineData[i+1:], '"')
+			if end < 0 {
+				end = len(lineData)
+			} else {
+				end += i + 2
+			}
+			tokens = append(tokens, FBToken{Kind: TokenString, Bytes: lineData[i:end]})
+			i = end - 1
+		} else if id >= 0x20 && id <= 0x5B {
+			tokens = append(tokens, FBToken{Kind: TokenRaw, Bytes: lineData[i : i+1]})
+			if id == ':' {
+				parsingData = false
+			}
+		} else if (id == 0x12 || id == 0x11 || id == 0x0B) && i+2 < len(lineData) {
+			kind := TokenDecimal
+			if id == 0x11 {
+				kind = TokenHex
+			} else if id == 0x0B {
+				kind = TokenLineNumber
+			}
+			tokens = append(tokens, FBToken{Kind: kind, Bytes: lineData[i : i+3]})
+			i += 2
+		} else if id >= 0x01 && id <= 0x0A {
+			tokens = append(tokens, FBToken{Kind: TokenShort, Bytes: lineData[i : i+1]})
+		} else {
+			tokens = append(tokens, FBToken{Kind: TokenUnknown, Bytes: lineData[i : i+1]})
+		}
+	}
+
+	return tokens
+}
+
+// fbByteTokenKind returns the kind of token a single byte is read as.
+func fbByteTokenKind(c byte, dialect FBDialect) FBTokenKind {
+	if dialect.HasKeyword(c) {
+		return TokenKeyword
+	} else if c >= 0x20 && c <= 0x5B {
+		return TokenRaw
+	} else {
+		return TokenUnknown
+	}
+}
+
+type fbLineParser struct {
+	sourceLine   int
+	line         string
+	dialect      FBDialect
+	keepComments bool // whether //! comments are turned into REM
+	tokens       []FBToken
+	comment      string
+	diags        []FBDiagnostic
+}
+
+// column returns the column of the character remaining bytes before the
+// end of the line.
+func (p *fbLineParser) column(remaining int) int {
+	return utf8.RuneCountInString(p.line[:len(p.line)-remaining]) + 1
+}
+
+func (p *fbLineParser) emit(remaining int, kind FBTokenKind, data ...byte) {
+	p.tokens = append(p.tokens, FBToken{Kind: kind, Bytes: data, Column: p.column(remaining)})
+}
+
+func (p *fbLineParser) report(remaining int, text string, severity FBSeverity, kind FBDiagnosticKind, format string, args ...interface{}) {
+	p.diags = append(p.diags, FBDiagnostic{
+		Line:     p.sourceLine,
+		Column:   p.column(remaining),
+		Text:     text,
+		Severity: severity,
+		Kind:     kind,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// skip reports the run of characters at the start of rest for which
+// invalid is true as unexpected, and returns the text following it. The
+// line continues for tail bytes after rest.
+func (p *fbLineParser) skip(rest string, tail int, invalid func(string) bool) string {
+	size := 0
+	for size < len(rest) && (size == 0 || invalid(rest[size:])) {
+		_, n := utf8.DecodeRuneInString(rest[size:])
+		size += n
+	}
+	p.report(len(rest)+tail, rest[:size], SeverityError, DiagInvalidCharacter, "unexpected character '%s'", rest[:size])
+	return rest[size:]
+}
+
+func isUntranslatable(s string) bool {
+	if s[0] == '{' {
+		return false
+	}
+	_, n := utf8.DecodeRuneInString(s)
+	_, rest := fbStringToBytesPrefix(s[:n])
+	return rest != ""
+}
+
+func isInvalidOutsideString(s string) bool {
+	return s[0] != '{' && (s[0] < 0x20 || s[0] > 0x5B)
+}
+
+type fbEscapeKind int
+
+const (
+	fbEscapeEmpty fbEscapeKind = iota
+	fbEscapeByte
+	fbEscapeNumber
+	fbEscapeKeyword
+)
+
+type fbEscape struct {
+	kind   fbEscapeKind
+	value  byte
+	number FBTokenKind
+}
+
+var fbNumberEscapes = map[string]FBTokenKind{
+	"L": TokenLineNumber,
+	"D": TokenDecimal,
+	"S": TokenShort,
+}
+
+// escape parses the annotation at the start of s, returning its length.
+// The line continues for tail bytes after s.
+func (p *fbLineParser) escape(s string, tail int) (fbEscape, int, bool) {
+	end := strings.IndexByte(s, '}')
+	if end < 0 {
+		p.report(len(s)+tail, s, SeverityError, DiagInvalidEscape, "unterminated escape '%s'", s)
+		return fbEscape{}, len(s), false
+	}
+	name := s[1:end]
+	if name == "" {
+		return fbEscape{kind: fbEscapeEmpty}, end + 1, true
+	}
+	if len(name) == 3 && name[0] == '$' {
+		if v, err := strconv.ParseUint(name[1:], 16, 8); err == nil {
+			return fbEscape{kind: fbEscapeByte, value: byte(v)}, end + 1, true
+		}
+	}
+	if kind, ok := fbNumberEscapes[name]; ok {
+		return fbEscape{kind: fbEscapeNumber, number: kind}, end + 1, true
+	}
+	for k, v := range idToKeywordMap {
+		if v != name {
+			continue
+		} else if !p.dialect.HasKeyword(k) {
+			p.report(len(s)+tail, s[:end+1], SeverityError, DiagUnsupportedKeyword, "keyword %s is not available in Family BASIC %v", name, p.dialect)
+			return fbEscape{}, end + 1, false
+		}
+		return fbEscape{kind: fbEscapeKeyword, value: k}, end + 1, true
+	}
+	p.report(len(s)+tail, s[:end+1], SeverityError, DiagInvalidEscape, "unknown escape '%s'", s[:end+1])
+	return fbEscape{}, end + 1, false
+}
+
+func (p *fbLineParser) stringToBytes(s string, tail int) []byte {
+	var buf bytes.Buffer
+	for {
+		v, rest := fbStringToBytesPrefix(s)
+		buf.Write(v)
+		if rest == "" {
+			return buf.Bytes()
+		}
+		if rest[0] != '{' {
+			s = p.skip(rest, tail, isUntranslatable)
+			continue
+		}
+		esc, n, ok := p.escape(rest, tail)
+		if ok {
+			switch esc.kind {
+			case fbEscapeByte, fbEscapeKeyword:
+				buf.WriteByte(esc.value)
+			case fbEscapeNumber:
+				p.report(len(rest)+tail, rest[:n], SeverityError, DiagInvalidEscape, "number escape '%s' not allowed here", rest[:n])
+			}
+		}
+		s = rest[n:]
+	}
+}
+
+// digits parses the decimal number at the start of s, returning its value
+// and length.
+func (p *fbLineParser) digits(s string) (int, int) {
+	x := 0
+	for x < len(s) && s[x] >= '0' && s[x] <= '9' {
+		x++
+	}
+	v, err := strconv.Atoi(s[:x])
+	if err != nil || v >= 65536 {
+		p.report(len(s), s[:x], SeverityError, DiagValueOutOfRange, "value out of range: %s", s[:x])
+		v = 0
+	}
+	return v, x
+}
+
+func (p *fbLineParser) emitNumber(remaining int, kind FBTokenKind, v int) {
+	token := NewFBNumberToken(kind, v)
+	token.Column = p.column(remaining)
+	p.tokens = append(p.tokens, token)
+}
+
+// sourceComment ends the line with a // comment, which is only kept in
+// the source text unless it is turned into a REM.
+func (p *fbLineParser) sourceComment(s string) {
+	// spaces before the comment are only formatting
+	for len(p.tokens) > 0 && p.tokens[len(p.tokens)-1].Kind == TokenRaw && p.tokens[len(p.tokens)-1].Bytes[0] == ' ' {
+		p.tokens = p.tokens[:len(p.tokens)-1]
+	}
+	if !p.keepComments || !strings.HasPrefix(s, "//!") {
+		p.comment = s
+		return
+	}
+	if len(p.tokens) > 0 {
+		p.emit(len(s), TokenRaw, ':')
+	}
+	text := strings.TrimLeft(s[3:], " ")
+	data := []byte{0x95} // REM
+	if text != "" {
+		data = append(data, ' ')
+		data = append(data, p.stringToBytes(FBUpperCase(text), 0)...)
+	}
+	p.emit(len(s), TokenComment, data...)
+}
+
+// fbKeywordPrefix returns the longest keyword of the dialect which s
+// starts with, and its length, or a length of 0.
+func fbKeywordPrefix(s string, dialect FBDialect) (byte, int) {
+	id, n := byte(0), 0
+	for k, v := range idToKeywordMap {
+		if len(v) > n && strings.HasPrefix(s, v) && dialect.HasKeyword(k) {
+			id, n = k, len(v)
+		}
+	}
+	return id, n
+}
+
+// parse tokenizes the line, returning false if it does not contain a
+// program line.
+func (p *fbLineParser) parse() (FBLine, bool) {
+	line := strings.TrimLeft(p.line, " \t")
+	if strings.TrimSpace(line) == "" {
+		return FBLine{}, false
+	} else if strings.HasPrefix(line, "//") {
+		p.comment = line
+		return FBLine{}, false
+	}
+
+	x := 0
+	for x < len(line) && line[x] >= '0' && line[x] <= '9' {
+		x++
+	}
+	if x == 0 {
+		text := strings.Fields(line)[0]
+		p.report(len(line), text, SeverityError, DiagMissingLineNumber, "line number expected, found '%s'", text)
+		return FBLine{}, false
+	}
+	lineNumber, err := strconv.Atoi(line[:x])
+	if err != nil || lineNumber >= 65536 {
+		p.report(len(line), line[:x], SeverityError, DiagInvalidLineNumber, "invalid line number %s", line[:x])
+		return FBLine{}, false
+	}
+	s := strings.TrimPrefix(line[x:], " ")
+	if s == "" {
+		p.report(len(line), line[:x], SeverityWarning, DiagEmptyLine, "line %d is empty and will be ignored", lineNumber)
+		return FBLine{}, false
+	}
+
+	// Keywords are crunched by taking the longest keyword starting at the
+	// current character, wherever it is, so a name like TOTAL reads as TO,
+	// T, A, L. Digits following a letter belong to a variable name.
+	// Strings, REM and ' are stored as typed, as is DATA up to the next ':'
+	// outside quotes. Outside of those, // starts a comment which is not
+	// part of the program.
+	readingLineNumbers := false
+	parsingData := false
+	currAlpha := false
+	lastAlpha := false
+	for len(s) > 0 {
+		remaining := len(s)
+		lastAlpha = currAlpha
+		currAlpha = false
+		if s[0] == 0x20 {
+			// skip spaces early (so "GOTO"[space]"line number") works
+			p.emit(remaining, TokenRaw, 0x20)
+			s = s[1:]
+			continue
+		}
+		if strings.HasPrefix(s, "//") {
+			p.sourceComment(s)
+			break
+		}
+		if parsingData && s[0] != '"' && s[0] != ':' && s[0] != '{' {
+			end := strings.IndexAny(s, "\":{")
+			if end < 0 {
+				end = len(s)
+			}
+			if comment := strings.Index(s[:end], "//"); comment >= 0 {
+				end = comment
+			}
+			column := p.column(remaining)
+			for _, c := range p.stringToBytes(s[:end], len(s)-end) {
+				p.tokens = append(p.tokens, FBToken{Kind: TokenRaw, Bytes: []byte{c}, Column: column})
+			}
+			s = s[end:]
+			continue
+		}
+		prefixByte := byte(0)
+		prefixLen := 0
+		if s[0] == '{' {
+			esc, n, ok := p.escape(s, 0)
+			if !ok {
+				s = s[n:]
+				continue
+			}
+			switch esc.kind {
+			case fbEscapeEmpty:
+				// only separates the text around it
+				currAlpha = lastAlpha
+				s = s[n:]
+				continue
+			case fbEscapeByte:
+				p.emit(remaining, fbByteTokenKind(esc.value, p.dialect), esc.value)
+				s = s[n:]
+				continue
+			case fbEscapeNumber:
+				if len(s) == n || s[n] < '0' || s[n] > '9' {
+					p.report(remaining, s[:n], SeverityError, DiagInvalidEscape, "number expected after '%s'", s[:n])
+					s = s[n:]
+					continue
+				}
+				v, x := p.digits(s[n:])
+				if esc.number == TokenShort && v >= 10 {
+					p.report(remaining, s[:n+x], SeverityError, DiagValueOutOfRange, "value out of range: %s", s[:n+x])
+					v = 0
+				}
+				p.emitNumber(remaining, esc.number, v)
+				s = s[n+x:]
+				continue
+			case fbEscapeKeyword:
+				prefixByte = esc.value
+				prefixLen = n
+			}
+		} else {
+			prefixByte, prefixLen = fbKeywordPrefix(s, p.dialect)
+		}
+		if prefixLen > 0 {
+			currKeyword := idToKeywordMap[prefixByte]
+			if currKeyword == "REM" {
+				p.emit(remaining, TokenComment, append([]byte{prefixByte}, p.stringToBytes(s[prefixLen:], 0)...)...)
+				break
+			}
+			p.emit(remaining, TokenKeyword, prefixByte)
+			s = s[prefixLen:]
+			parsingData = (currKeyword == "DATA")
+			readingLineNumbers = (currKeyword == "GOSUB" || currKeyword == "GOTO" || currKeyword == "RETURN" || currKeyword == "RESTORE" || currKeyword == "RUN" || currKeyword == "THEN")
+		} else if s[0] == '\'' {
+			// comment
+			p.emit(remaining, TokenComment, p.stringToBytes(s, 0)...)
+			break
+		} else if s[0] == '"' {
+			// string
+			splitPos := strings.Index(s[1:], "\"")
+			if splitPos < 0 {
+				p.emit(remaining, TokenString, append([]byte{'"'}, p.stringToBytes(s[1:], 0)...)...)
+				break
+			}
+			p.emit(remaining, TokenString, append([]byte{'"'}, p.stringToBytes(s[1:splitPos+2], len(s)-splitPos-2)...)...)
+			s = s[splitPos+2:]
+		} else if !lastAlpha && s[0] >= '0' && s[0] <= '9' {
+			// digits
+			v, x := p.digits(s)
+			if readingLineNumbers {
+				p.emitNumber(remaining, TokenLineNumber, v)
+			} else if v >= 10 || v == 0 {
+				// zero is stored as a decimal constant, as seen in Enri's notes
+				p.emitNumber(remaining, TokenDecimal, v)
+			} else {
+				p.emitNumber(remaining, TokenShort, v)
+			}
+			s = s[x:]
+		} else if len(s) >= 2 && s[0] == '&' && s[1] == 'H' {
+			// hex number
+			x := 2
+			for x < len(s) && ((s[x] >= '0' && s[x] <= '9') || (s[x] >= 'A' && s[x] <= 'F')) {
+				x++
+			}
+			v, err := strconv.ParseInt(s[2:x], 16, 32)
+			if err != nil || v >= 65536 {
+				p.report(remaining, s[:x], SeverityError, DiagValueOutOfRange, "value out of range: %s", s[:x])
+				v = 0
+			}
+			p.emitNumber(remaining, TokenHex, int(v))
+			s = s[x:]
+		} else if s[0] >= 0x21 && s[0] <= 0x5B {
+			// other character
+			p.emit(remaining, TokenRaw, s[0])
+			if s[0] != ',' {
+				readingLineNumbers = false
+			}
+			if s[0] == ':' {
+				parsingData = false
+			}
+			if (s[0] >= 0x41 && s[0] <= 0x5B) || (lastAlpha && s[0] >= '0' && s[0] <= '9') {
+				currAlpha = true
+			}
+			s = s[1:]
+		} else {
+			s = p.skip(s, 0, isInvalidOutsideString)
+		}
+	}
+
+	if len(p.tokens) == 0 && p.comment != "" {
+		p.report(len(line), line[:x], SeverityWarning, DiagEmptyLine, "line %d is empty and will be ignored", lineNumber)
+		return FBLine{}, false
+	}
+	result := FBLine{Number: lineNumber, Tokens: p.tokens, Source: p.sourceLine, Comment: p.comment}
+	if lineLength := len(result.Bytes()); lineLength >= 253 {
+		p.report(len(line), line[:x], SeverityError, DiagLineTooLong, "line %d is too long (%d bytes, at most 252 allowed)", lineNumber, lineLength)
+	}
+	return result, true
+}
+
+// FBParseProgram tokenizes a program listing for the given dialect. Every
+// line is checked, and all problems found are returned as diagnostics.
+// Directives before the first line, such as "#name GAME", describe the
+// program's tape file; "#dialect" replaces the given dialect.
+func FBParseProgram(s string, dialect FBDialect) (*FBProgram, []FBDiagnostic) {
+	return FBParseProgramWithOptions(s, dialect, FBParseOptions{})
+}
+
+type FBParseOptions struct {
+	// KeepComments turns // comments starting with //! into REM
+	// statements, rather than leaving them out.
+	KeepComments bool
+	// UpperCase reads the listing as if written in upper case; // comments
+	// are kept as written.
+	UpperCase bool
+}
+
+// FBParseProgramWithOptions tokenizes a program listing like
+// FBParseProgram, as changed by the options.
+func FBParseProgramWithOptions(s string, dialect FBDialect, opts FBParseOptions) (*FBProgram, []FBDiagnostic) {
+	program := &FBProgram{Dialect: dialect}
+	var diags []FBDiagnostic
+	var comments []string
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		if directive := strings.TrimLeft(line, " \t"); strings.HasPrefix(directive, "#") {
+			name := strings.ToLower(strings.Fields(directive)[0])
+			d := FBDiagnostic{Line: i + 1, Column: len(line) - len(directive) + 1, Text: name, Severity: SeverityError, Kind: DiagDirective}
+			if isFBHeaderDirective(name) && len(program.Lines) > 0 {
+				d.Message = fmt.Sprintf("%s must come before the first line", name)
+				diags = append(diags, d)
+			} else if err := program.Header.set(name, directive[len(name):]); err != nil {
+				d.Message = err.Error()
+				diags = append(diags, d)
+			} else if program.Header.HasDialect {
+				dialect = program.Header.Dialect
+				program.Dialect = dialect
+			}
+			continue
+		}
+		p := fbLineParser{sourceLine: i + 1, line: line, dialect: dialect, keepComments: opts.KeepComments}
+		if opts.UpperCase {
+			p.line = FBUpperCase(line)
+		}
+		l, ok := p.parse()
+		diags = append(diags, p.diags...)
+		// comments end the line, and upper casing keeps its length
+		comment := line[len(line)-len(p.comment):]
+		if ok {
+			if l.Comment != "" {
+				l.Comment = comment
+			}
+			l.CommentLines = comments
+			comments = nil
+			program.Lines = append(program.Lines, l)
+		} else if p.comment != "" {
+			comments = append(comments, comment)
+		}
+	}
+	program.CommentLines = comments
+	return program, diags
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import "fmt"
+
+// FBLineReference is a line number reference found in a program.
+type FBLineReference struct {
+	Line   int // the line containing the reference
+	Column int // 1-based column in the source text, or 0
+	Target int
+}
+
+func (r FBLineReference) String() string {
+	return fmt.Sprintf("line %d: reference to missing line %d", r.Line, r.Target)
+}
+
+// References returns every line number reference in the program.
+func (p *FBProgram) References() []FBLineReference {
+	var refs []FBLineReference
+	for _, l := range p.Lines {
+		for _, t := range l.Tokens {
+			if t.Kind == TokenLineNumber {
+				refs = append(refs, FBLineReference{Line: l.Number, Column: t.Column, Target: t.Value()})
+			}
+		}
+	}
+	return refs
+}
+
+// DanglingReferences returns the line number references pointing to lines
+// which do not exist.
+func (p *FBProgram) DanglingReferences() []FBLineReference {
+	exists := make(map[int]bool)
+	for _, l := range p.Lines {
+		exists[l.Number] = true
+	}
+	var dangling []FBLineReference
+	for _, r := range p.References() {
+		if !exists[r.Target] {
+			dangling = append(dangling, r)
+		}
+	}
+	return dangling
+}
+
+// Renumber renumbers the lines numbered from first to last, starting at
+// start and counting up by step, and rewrites every reference to them.
+// References to missing lines are left as they are, and returned with the
+// new number of the line containing them. The program is left unchanged if
+// the new numbers would collide with other lines or change their order.
+func (p *FBProgram) Renumber(start, step, first, last int) ([]FBLineReference, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("invalid step %d", step)
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("invalid start %d", start)
+	}
+
+	mapping := make(map[int]int)
+	numbers := make([]int, len(p.Lines))
+	next := start
+	for i, l := range p.Lines {
+		numbers[i] = l.Number
+		if l.Number < first || l.Number > last {
+			continue
+		}
+		if _, ok := mapping[l.Number]; ok {
+			return nil, fmt.Errorf("line %d appears more than once", l.Number)
+		}
+		if next > 65535 {
+			return nil, fmt.Errorf("line %d would be renumbered past 65535", l.Number)
+		}
+		mapping[l.Number] = next
+		numbers[i] = next
+		next += step
+	}
+	for i := 1; i < len(numbers); i++ {
+		if numbers[i] <= numbers[i-1] {
+			return nil, fmt.Errorf("lines would be out of order: %d follows %d", numbers[i], numbers[i-1])
+		}
+	}
+
+	dangling := p.DanglingReferences()
+	for i := range p.Lines {
+		l := &p.Lines[i]
+		for j, t := range l.Tokens {
+			if t.Kind != TokenLineNumber {
+				continue
+			}
+			if target, ok := mapping[t.Value()]; ok {
+				token := NewFBNumberToken(TokenLineNumber, target)
+				token.Column = t.Column
+				l.Tokens[j] = token
+			}
+		}
+		l.Number = numbers[i]
+	}
+	for i, r := range dangling {
+		if n, ok := mapping[r.Line]; ok {
+			dangling[i].Line = n
+		}
+	}
+	return dangling, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenumber(t *testing.T) {
+	program, diags := FBParseProgram("1 ON X GOTO 5,7\n5 GOSUB 7:RESTORE 9\n7 IF A THEN 1\n8 GOTO 25\n9 DATA 1\n100 RUN 5\n", DefaultFBDialect)
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	dangling, err := program.Renumber(10, 10, 0, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "10 ON X GOTO 20,30\n20 GOSUB 30:RESTORE 50\n30 IF A THEN 10\n40 GOTO 25\n50 DATA 1\n100 RUN 20\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	if len(dangling) != 1 || dangling[0] != (FBLineReference{Line: 40, Column: 8, Target: 25}) {
+		t.Errorf("unexpected dangling references %v", dangling)
+	}
+
+	if _, err := program.Renumber(100, 10, 20, 40); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("expected an ordering error, got %v", err)
+	}
+	if _, err := program.Renumber(-10, 10, 0, 99); err == nil {
+		t.Error("expected an error for a negative start")
+	}
+	if program.String() != expected {
+		t.Errorf("program changed by failed renumbering:\n%s", program.String())
+	}
+}
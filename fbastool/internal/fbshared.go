@@ -48,6 +48,16 @@ func FBByteToString(c byte) string {
 }
 
 func FBStringToBytes(s string) ([]byte, error) {
+	v, rest := fbStringToBytesPrefix(s)
+	if rest != "" {
+		return v, fmt.Errorf("cannot translate from '%s'", rest)
+	}
+	return v, nil
+}
+
+// fbStringToBytesPrefix translates as much of s as possible, returning the
+// part which could not be translated.
+func fbStringToBytesPrefix(s string) ([]byte, string) {
 	var buf bytes.Buffer
 
 	for len(s) > 0 {
@@ -66,11 +76,11 @@ func FBStringToBytes(s string) ([]byte, error) {
 			}
 		}
 		if !found {
-			return buf.Bytes(), fmt.Errorf("cannot translate from '%s'", s)
+			return buf.Bytes(), s
 		}
 	}
 
-	return buf.Bytes(), nil
+	return buf.Bytes(), ""
 }
 
 func init() {
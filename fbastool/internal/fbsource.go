@@ -0,0 +1,394 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// FBSourceLocation is a line of a source file.
+type FBSourceLocation struct {
+	File string
+	Line int
+}
+
+func (l FBSourceLocation) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// FBSourceMapEntry gives the source line a program line was written on.
+type FBSourceMapEntry struct {
+	Number   int
+	Location FBSourceLocation
+}
+
+type FBSourceMap []FBSourceMapEntry
+
+// String returns the map as text, one "number file:line" entry per line.
+func (m FBSourceMap) String() string {
+	var s strings.Builder
+	for _, e := range m {
+		fmt.Fprintf(&s, "%d %v\n", e.Number, e.Location)
+	}
+	return s.String()
+}
+
+// fbSourceText is text taken from a source line, which keeps the source
+// column of each of its bytes as it is cut and rewritten.
+type fbSourceText struct {
+	location FBSourceLocation
+	text     string
+	columns  []int
+}
+
+func newFBSourceText(location FBSourceLocation, text string) fbSourceText {
+	t := fbSourceText{location: location, text: text, columns: make([]int, len(text))}
+	column := 1
+	for i, r := range text {
+		for j := 0; j < utf8.RuneLen(r); j++ {
+			t.columns[i+j] = column
+		}
+		column++
+	}
+	return t
+}
+
+// column returns the source column of the byte at i, or of the end of
+// the text.
+func (t fbSourceText) column(i int) int {
+	if i < len(t.columns) {
+		return t.columns[i]
+	} else if len(t.columns) > 0 {
+		return t.columns[len(t.columns)-1] + 1
+	}
+	return 1
+}
+
+// sourceColumn converts a column counted in characters of the text to a
+// column of the source line.
+func (t fbSourceText) sourceColumn(column int) int {
+	i := 0
+	for n := 1; n < column && i < len(t.text); n++ {
+		_, size := utf8.DecodeRuneInString(t.text[i:])
+		i += size
+	}
+	return t.column(i)
+}
+
+func (t fbSourceText) slice(i, j int) fbSourceText {
+	return fbSourceText{location: t.location, text: t.text[i:j], columns: t.columns[i:j]}
+}
+
+func (t fbSourceText) trimLeft() fbSourceText {
+	return t.slice(len(t.text)-len(strings.TrimLeft(t.text, " \t")), len(t.text))
+}
+
+// append adds text, which is given the source column column.
+func (t fbSourceText) append(text string, column int) fbSourceText {
+	columns := append(t.columns[:len(t.columns):len(t.columns)], make([]int, len(text))...)
+	for i := len(t.text); i < len(columns); i++ {
+		columns[i] = column
+	}
+	return fbSourceText{location: t.location, text: t.text + text, columns: columns}
+}
+
+func (t fbSourceText) appendText(u fbSourceText) fbSourceText {
+	return fbSourceText{location: t.location, text: t.text + u.text, columns: append(t.columns[:len(t.columns):len(t.columns)], u.columns...)}
+}
+
+func fbSourceTexts(filename string, s string) []fbSourceText {
+	var lines []fbSourceText
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		lines = append(lines, newFBSourceText(FBSourceLocation{File: filename, Line: i + 1}, line))
+	}
+	return lines
+}
+
+type FBSourceOptions struct {
+	Filename string
+	Start    int // number of the first line without one
+	Step     int
+	// Include reads an included file, named relative to the current
+	// directory.
+	Include func(filename string) (string, error)
+	// Structured allows block IF, WHILE and SUB statements and long
+	// variable names.
+	Structured bool
+	// KeepComments turns // comments starting with //! into REM
+	// statements, rather than leaving them out.
+	KeepComments bool
+}
+
+type fbSourceLine struct {
+	text   fbSourceText // the line, starting with its number if it has one
+	number int
+	auto   bool
+}
+
+type fbCompiler struct {
+	dialect FBDialect
+	opts    FBSourceOptions
+	labels  map[string]int
+	defines map[string]string
+	files   []string // files being preprocessed, innermost last
+	header  FBHeader
+	started bool // whether a line of the program has been read
+	diags   []FBDiagnostic
+}
+
+func (c *fbCompiler) report(t fbSourceText, i int, text string, severity FBSeverity, kind FBDiagnosticKind, format string, args ...interface{}) {
+	c.diags = append(c.diags, FBDiagnostic{
+		File:     t.location.File,
+		Line:     t.location.Line,
+		Column:   t.column(i),
+		Text:     text,
+		Severity: severity,
+		Kind:     kind,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func isFBLabelByte(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+// label returns the length of the label name following an @ at the start
+// of s, or 0.
+func fbLabelLength(s string) int {
+	n := 1
+	for n < len(s) && isFBLabelByte(s[n]) {
+		n++
+	}
+	return n - 1
+}
+
+// number numbers the lines, collecting the labels placed before them.
+func (c *fbCompiler) number(texts []fbSourceText) []fbSourceLine {
+	var lines []fbSourceLine
+	var pending []fbSourceText
+	numbers := make(map[int]FBSourceLocation)
+	next := c.opts.Start
+	for _, t := range texts {
+		t = t.trimLeft()
+		for strings.HasPrefix(t.text, "@") {
+			n := fbLabelLength(t.text)
+			if n == 0 || n+1 >= len(t.text) || t.text[n+1] != ':' {
+				break
+			}
+			pending = append(pending, t.slice(0, n+1))
+			t = t.slice(n+2, len(t.text)).trimLeft()
+		}
+		if strings.TrimSpace(t.text) == "" {
+			continue
+		}
+
+		line := fbSourceLine{text: t, auto: t.text[0] < '0' || t.text[0] > '9'}
+		if line.auto {
+			line.number = next
+			if next > 65535 {
+				c.report(t, 0, "", SeverityError, DiagInvalidLineNumber, "line number %d is past 65535", next)
+			}
+		} else {
+			x := 0
+			for x < len(t.text) && t.text[x] >= '0' && t.text[x] <= '9' {
+				x++
+			}
+			var err error
+			if line.number, err = strconv.Atoi(t.text[:x]); err != nil || line.number > 65535 {
+				// reported when parsing the line
+				continue
+			}
+		}
+		if location, ok := numbers[line.number]; ok {
+			c.report(t, 0, "", SeverityError, DiagLineCollision, "line %d is also used at %v", line.number, location)
+		}
+		numbers[line.number] = t.location
+		// numbered lines from included files are kept apart from the
+		// numbering around them
+		if line.auto || t.location.File == c.opts.Filename {
+			next = line.number + c.opts.Step
+		}
+
+		for _, l := range pending {
+			name := strings.ToUpper(l.text[1:])
+			if _, ok := c.labels[name]; ok {
+				c.report(l, 0, l.text, SeverityError, DiagDuplicateLabel, "label %s is already defined", l.text)
+			}
+			c.labels[name] = line.number
+		}
+		pending = nil
+		lines = append(lines, line)
+	}
+	for _, l := range pending {
+		c.report(l, 0, l.text, SeverityError, DiagUnknownLabel, "label %s is not followed by a line", l.text)
+	}
+	// numbered lines, such as those of included fragments, go where their
+	// numbers put them
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].number < lines[j].number
+	})
+	return lines
+}
+
+// resolve replaces labels with the numbers of their lines, outside of
+// strings, comments and DATA.
+func (c *fbCompiler) resolve(t fbSourceText) fbSourceText {
+	result := t.slice(0, 0)
+	start := 0
+	inString, data := false, false
+	for i := 0; i < len(t.text); i++ {
+		s := t.text[i:]
+		if s[0] == '"' {
+			inString = !inString
+		}
+		if inString {
+			continue
+		}
+		if s[0] == '\'' || strings.HasPrefix(s, "REM") {
+			break
+		} else if strings.HasPrefix(s, "DATA") {
+			data = true
+		} else if s[0] == ':' {
+			data = false
+		} else if s[0] == '@' && !data {
+			n := fbLabelLength(s)
+			if n == 0 {
+				continue
+			}
+			number, ok := c.labels[strings.ToUpper(s[1:n+1])]
+			if !ok {
+				c.report(t, i, s[:n+1], SeverityError, DiagUnknownLabel, "unknown label %s", s[:n+1])
+			}
+			result = result.appendText(t.slice(start, i)).append(strconv.Itoa(number), t.column(i))
+			start = i + n + 1
+			i += n
+		}
+	}
+	return result.appendText(t.slice(start, len(t.text)))
+}
+
+func (c *fbCompiler) compile(texts []fbSourceText) (*FBProgram, FBSourceMap) {
+	program := &FBProgram{Dialect: c.dialect}
+	var sourceMap FBSourceMap
+	for _, line := range c.number(texts) {
+		text := c.resolve(line.text)
+		if line.auto {
+			text = text.slice(0, 0).append(strconv.Itoa(line.number)+" ", text.column(0)).appendText(text)
+		}
+		l, ok := c.parse(text)
+		if !ok {
+			continue
+		}
+		program.Lines = append(program.Lines, l)
+		sourceMap = append(sourceMap, FBSourceMapEntry{Number: l.Number, Location: text.location})
+	}
+	return program, sourceMap
+}
+
+// parse tokenizes a numbered line, with columns counted in the source.
+func (c *fbCompiler) parse(text fbSourceText) (FBLine, bool) {
+	p := fbLineParser{sourceLine: text.location.Line, line: text.text, dialect: c.dialect}
+	l, ok := p.parse()
+	for _, d := range p.diags {
+		d.File = text.location.File
+		d.Column = text.sourceColumn(d.Column)
+		c.diags = append(c.diags, d)
+	}
+	for i := range l.Tokens {
+		l.Tokens[i].Column = text.sourceColumn(l.Tokens[i].Column)
+	}
+	return l, ok
+}
+
+// sortedDiags returns the problems found, those of each file together and
+// in order.
+func (c *fbCompiler) sortedDiags() []FBDiagnostic {
+	files := map[string]int{c.opts.Filename: 0}
+	for _, d := range c.diags {
+		if _, ok := files[d.File]; !ok {
+			files[d.File] = len(files)
+		}
+	}
+	sort.SliceStable(c.diags, func(i, j int) bool {
+		a, b := c.diags[i], c.diags[j]
+		if files[a.File] != files[b.File] {
+			return files[a.File] < files[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return c.diags
+}
+
+// FBCompileSource tokenizes a program written in source form: lines may
+// leave out their numbers, which are then counted on from the line before
+// (or opts.Start) by opts.Step, and be labelled as in "@loop:". A label
+// can be used wherever a line number can, as in "GOTO @loop". Lines
+// starting with # are preprocessor directives, or header directives as
+// read by FBParseProgram.
+func FBCompileSource(s string, dialect FBDialect, opts FBSourceOptions) (*FBProgram, FBSourceMap, []FBDiagnostic) {
+	c := fbCompiler{dialect: dialect, opts: opts, labels: make(map[string]int), defines: make(map[string]string)}
+	texts := c.preprocess(opts.Filename, s)
+	if opts.Structured {
+		texts = c.structure(texts)
+	}
+	program, sourceMap := c.compile(texts)
+	program.Header = c.header
+	return program, sourceMap, c.sortedDiags()
+}
+
+// FBPreprocessProgram tokenizes a program listing like FBParseProgram,
+// after running its preprocessor directives as FBCompileSource does. Every
+// line needs a number; opts.Start, opts.Step and opts.Structured are not
+// used.
+//
+// Lines of included files go where their numbers put them, and must not
+// use a number found elsewhere. The listing's own lines are otherwise kept
+// as they are, in the order written and even with repeated numbers, so
+// that a decoded program encodes back to the same bytes.
+func FBPreprocessProgram(s string, dialect FBDialect, opts FBSourceOptions) (*FBProgram, []FBDiagnostic) {
+	c := fbCompiler{dialect: dialect, opts: opts, labels: make(map[string]int), defines: make(map[string]string)}
+	texts := c.preprocess(opts.Filename, s)
+	program := &FBProgram{Dialect: c.dialect, Header: c.header}
+	numbers := make(map[int]FBSourceLocation)
+	included := false
+	for _, text := range texts {
+		l, ok := c.parse(text)
+		if !ok {
+			continue
+		}
+		if location, ok := numbers[l.Number]; ok && (location.File != opts.Filename || text.location.File != opts.Filename) {
+			c.report(text, 0, "", SeverityError, DiagLineCollision, "line %d is also used at %v", l.Number, location)
+		}
+		numbers[l.Number] = text.location
+		included = included || text.location.File != opts.Filename
+		program.Lines = append(program.Lines, l)
+	}
+	if included {
+		sort.SliceStable(program.Lines, func(i, j int) bool {
+			return program.Lines[i].Number < program.Lines[j].Number
+		})
+	}
+	return program, c.sortedDiags()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"testing"
+)
+
+func TestCompileSource(t *testing.T) {
+	source := "  CLS\n@loop:\n  FOR I=0 TO 9:PRINT I:NEXT\n  GOSUB @sub\n  IF INKEY$=\"\" THEN @LOOP\n\f\v\u00a0\n@sub: PRINT \"@SUB\":RETURN\n500 RESTORE @data\n@data:\n  DATA 1,@X\n"
+	program, sourceMap, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "a.bas", Start: 100, Step: 5})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "100 CLS\n105 FOR I=0 TO 9:PRINT I:NEXT\n110 GOSUB 120\n115 IF INKEY$=\"\" THEN 105\n120 PRINT \"@SUB\":RETURN\n500 RESTORE 505\n505 DATA 1,@X\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	expectedMap := "100 a.bas:1\n105 a.bas:3\n110 a.bas:4\n115 a.bas:5\n120 a.bas:7\n500 a.bas:8\n505 a.bas:10\n"
+	if sourceMap.String() != expectedMap {
+		t.Errorf("source map mismatch\nexpected:\n%s\nactual:\n%s", expectedMap, sourceMap.String())
+	}
+	if ref := program.References()[0]; ref.Column != 9 {
+		t.Errorf("expected reference in source column 9, got %d", ref.Column)
+	}
+}
+
+func TestCompileSourceDiagnostics(t *testing.T) {
+	source := "@a:\nGOTO @b ~\n@a: PRINT\n10 END\n@c:\n"
+	_, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Start: 10, Step: 10})
+	expected := []struct {
+		line, column int
+		kind         FBDiagnosticKind
+	}{
+		{2, 6, DiagUnknownLabel},
+		{2, 9, DiagInvalidCharacter},
+		{3, 1, DiagDuplicateLabel},
+		{4, 1, DiagLineCollision},
+		{5, 1, DiagUnknownLabel},
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diags)
+	}
+	for i, e := range expected {
+		if d := diags[i]; d.Line != e.line || d.Column != e.column || d.Kind != e.kind {
+			t.Errorf("diagnostic %d: expected %d:%d %v, got %d:%d %v", i, e.line, e.column, e.kind, d.Line, d.Column, d.Kind)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fbStructLine is a line of structured source after lowering; plain lines
+// are those written as they are, which may be joined onto an IF.
+type fbStructLine struct {
+	text  fbSourceText
+	plain bool
+}
+
+// fbBlock is a block statement being lowered.
+type fbBlock struct {
+	kind   string // IF, WHILE or SUB
+	start  fbSourceText
+	end    fbSourceText
+	id     int
+	arg    fbSourceText // the condition, or the name of a SUB
+	lines  []fbStructLine
+	els    []fbStructLine
+	inElse bool
+}
+
+func (b *fbBlock) add(lines ...fbStructLine) {
+	if b.inElse {
+		b.els = append(b.els, lines...)
+	} else {
+		b.lines = append(b.lines, lines...)
+	}
+}
+
+// fbCodeEnd returns the length of the code of a line, before any comment.
+func fbCodeEnd(s string) int {
+	inString := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			inString = !inString
+		} else if !inString && (s[i] == '\'' || strings.HasPrefix(s[i:], "REM")) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// fbBlockStatement splits a line of structured source into its statement
+// and argument, if it is a block statement. Comments after a block
+// statement are left out.
+func fbBlockStatement(t fbSourceText) (string, fbSourceText, bool) {
+	code := t.slice(0, fbCodeEnd(t.text)).trimLeft()
+	code = code.slice(0, len(strings.TrimRight(code.text, " \t:")))
+	upper := strings.ToUpper(code.text)
+	fields := strings.Fields(upper)
+	if len(fields) == 0 {
+		return "", code, false
+	}
+	switch {
+	case upper == "ELSE" || upper == "WEND":
+		return upper, code, true
+	case upper == "ENDIF" || upper == "END IF":
+		return "ENDIF", code, true
+	case upper == "ENDSUB" || upper == "END SUB":
+		return "ENDSUB", code, true
+	case strings.HasPrefix(upper, "IF") && strings.HasSuffix(upper, "THEN") && strings.TrimSpace(upper[2:len(upper)-4]) != "":
+		// THEN is crunched wherever it is, as in IF A=1THEN
+		arg := code.slice(2, len(code.text)-4).trimLeft()
+		return "IF", arg.slice(0, len(strings.TrimRight(arg.text, " \t"))), true
+	case fields[0] == "WHILE" && len(fields) > 1:
+		return "WHILE", code.slice(5, len(code.text)).trimLeft(), true
+	case fields[0] == "SUB" && len(fields) == 2:
+		return "SUB", code.slice(3, len(code.text)).trimLeft(), true
+	}
+	return "", code, false
+}
+
+// structure lowers block IF, WHILE and SUB statements to line numbers and
+// jumps, and gives long variable names short ones.
+func (c *fbCompiler) structure(texts []fbSourceText) []fbSourceText {
+	// subroutines are found first, so they can be called before they are
+	// defined
+	subs := make(map[string]bool)
+	for _, t := range texts {
+		if kind, arg, ok := fbBlockStatement(t); ok && kind == "SUB" {
+			subs[strings.ToUpper(arg.text)] = true
+		}
+	}
+
+	main := &fbBlock{}
+	var subLines []fbStructLine
+	stack := []*fbBlock{main}
+	ids := 0
+	for _, t := range texts {
+		top := stack[len(stack)-1]
+		line := t.trimLeft()
+		// labels before a block statement go on lines of their own
+		var labels []fbStructLine
+		for strings.HasPrefix(line.text, "@") {
+			n := fbLabelLength(line.text)
+			if n == 0 || n+1 >= len(line.text) || line.text[n+1] != ':' {
+				break
+			}
+			labels = append(labels, fbStructLine{text: line.slice(0, n+2)})
+			line = line.slice(n+2, len(line.text)).trimLeft()
+		}
+		kind, arg, ok := fbBlockStatement(line)
+		if !ok {
+			top.add(fbStructLine{text: c.callSubs(t, subs), plain: line.text != "" && len(labels) == 0 && (line.text[0] < '0' || line.text[0] > '9')})
+			continue
+		}
+		top.add(labels...)
+
+		switch kind {
+		case "IF", "WHILE", "SUB":
+			if kind == "SUB" && len(stack) > 1 {
+				c.report(line, 0, "SUB", SeverityError, DiagBlock, "SUB inside %s", top.kind)
+			} else if kind == "SUB" && !isFBIdentifier(arg.text) {
+				c.report(arg, 0, arg.text, SeverityError, DiagBlock, "invalid SUB name %s", arg.text)
+			}
+			ids++
+			stack = append(stack, &fbBlock{kind: kind, start: line, id: ids, arg: arg})
+		case "ELSE":
+			if top.kind != "IF" || top.inElse {
+				c.report(line, 0, kind, SeverityError, DiagBlock, "ELSE without IF")
+				continue
+			}
+			top.inElse = true
+		case "ENDIF", "WEND", "ENDSUB":
+			expected := map[string]string{"ENDIF": "IF", "WEND": "WHILE", "ENDSUB": "SUB"}[kind]
+			if top.kind != expected {
+				c.report(line, 0, kind, SeverityError, DiagBlock, "%s without %s", kind, expected)
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			top.end = line
+			if kind == "ENDSUB" {
+				subLines = append(subLines, c.lower(top)...)
+			} else {
+				stack[len(stack)-1].add(c.lower(top)...)
+			}
+		}
+	}
+	for _, b := range stack[1:] {
+		c.report(b.start, 0, "", SeverityError, DiagBlock, "%s without %s", b.kind, map[string]string{"IF": "ENDIF", "WHILE": "WEND", "SUB": "ENDSUB"}[b.kind])
+	}
+
+	lines := main.lines
+	last := len(lines) - 1
+	for last >= 0 && strings.TrimSpace(lines[last].text.text) == "" {
+		last--
+	}
+	// the program ends before its subroutines, and labels at the very end
+	// need a line to go to
+	if len(subLines) > 0 || (last >= 0 && fbIsLabelLine(lines[last].text)) {
+		var end fbSourceText
+		if last >= 0 {
+			end = lines[last].text
+		} else {
+			end = subLines[0].text
+		}
+		lines = append(lines, fbStructLine{text: end.slice(0, 0).append("END", 1)})
+	}
+	lines = append(lines, subLines...)
+
+	result := make([]fbSourceText, len(lines))
+	for i, l := range lines {
+		result[i] = l.text
+	}
+	return c.shortenNames(result)
+}
+
+func fbIsLabelLine(t fbSourceText) bool {
+	s := strings.TrimSpace(t.text)
+	n := fbLabelLength(s)
+	return strings.HasPrefix(s, "@") && n > 0 && s[n+1:] == ":"
+}
+
+// lower turns a finished block into plain lines.
+func (c *fbCompiler) lower(b *fbBlock) []fbStructLine {
+	column := b.start.column(0)
+	generated := func(format string, args ...interface{}) fbStructLine {
+		return fbStructLine{text: b.start.slice(0, 0).append(fmt.Sprintf(format, args...), column)}
+	}
+	withArg := func(before string, after string) fbStructLine {
+		return fbStructLine{text: b.start.slice(0, 0).append(before, column).appendText(b.arg).append(after, column)}
+	}
+
+	var lines []fbStructLine
+	switch b.kind {
+	case "IF":
+		if joined, ok := c.joinIf(b); ok {
+			return []fbStructLine{joined}
+		}
+		lines = append(lines, withArg("IF ", fmt.Sprintf(" THEN @_then%d", b.id)))
+		lines = append(lines, b.els...)
+		lines = append(lines, generated("GOTO @_endif%d", b.id), generated("@_then%d:", b.id))
+		lines = append(lines, b.lines...)
+		lines = append(lines, generated("@_endif%d:", b.id))
+	case "WHILE":
+		// the condition is tested at the bottom, which saves a jump
+		lines = append(lines, generated("GOTO @_wend%d", b.id), generated("@_while%d:", b.id))
+		lines = append(lines, b.lines...)
+		lines = append(lines, generated("@_wend%d:", b.id), withArg("IF ", fmt.Sprintf(" THEN @_while%d", b.id)))
+	case "SUB":
+		lines = append(lines, generated("@%s:", b.arg.text))
+		lines = append(lines, b.lines...)
+		lines = append(lines, fbStructLine{text: b.end.slice(0, 0).append("RETURN", b.end.column(0))})
+	}
+	return lines
+}
+
+// joinIf writes a block IF with a short body of plain lines as a single
+// line IF, where that means the same.
+func (c *fbCompiler) joinIf(b *fbBlock) (fbStructLine, bool) {
+	if b.inElse || len(b.lines) == 0 {
+		return fbStructLine{}, false
+	}
+	text := b.start.slice(0, 0).append("IF ", b.start.column(0)).appendText(b.arg).append(" THEN ", b.start.column(0))
+	for i, l := range b.lines {
+		body := l.text.trimLeft()
+		if !l.plain {
+			return fbStructLine{}, false
+		}
+		if i < len(b.lines)-1 {
+			// an IF or comment would take the rest of the line with it
+			upper := strings.ToUpper(body.text)
+			if fbCodeEnd(body.text) < len(body.text) || strings.Contains(upper[:fbCodeEnd(upper)], "IF") {
+				return fbStructLine{}, false
+			}
+			body = body.append(":", body.column(len(body.text)))
+		}
+		text = text.appendText(body)
+	}
+	if len(text.text) > 200 {
+		return fbStructLine{}, false
+	}
+	return fbStructLine{text: text}, true
+}
+
+// callSubs turns GOSUB followed by the name of a SUB into a jump to its
+// label.
+func (c *fbCompiler) callSubs(t fbSourceText, subs map[string]bool) fbSourceText {
+	result := t.slice(0, 0)
+	start := 0
+	end := fbCodeEnd(t.text)
+	upper := strings.ToUpper(t.text)
+	for i := strings.Index(upper, "GOSUB"); i >= 0 && i < end; {
+		j := i + 5
+		for j < len(t.text) && t.text[j] == ' ' {
+			j++
+		}
+		n := j
+		for n < len(t.text) && isFBLabelByte(t.text[n]) {
+			n++
+		}
+		if n > j && subs[upper[j:n]] {
+			result = result.appendText(t.slice(start, j)).append("@", t.column(j))
+			start = j
+		}
+		next := strings.Index(upper[n:], "GOSUB")
+		if next < 0 {
+			break
+		}
+		i = n + next
+	}
+	return result.appendText(t.slice(start, len(t.text)))
+}
+
+// fbNameWords calls f with the start and end of each keyword and variable
+// name, outside of strings, comments, DATA and labels. Keywords are found
+// in words written in upper case as the tokenizer finds them, wherever
+// they start, so that FORI=1TO9 reads as FOR I=1 TO 9; a word with
+// lowercase letters is a keyword only if it is one as a whole.
+func fbNameWords(s string, dialect FBDialect, f func(start, end int, keyword bool)) {
+	inString, data := false, false
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			inString = !inString
+		}
+		if inString {
+			continue
+		}
+		if s[i] == '\'' {
+			return
+		} else if s[i] == ':' {
+			data = false
+		}
+		if !isFBIdentifierStart(s[i]) || data {
+			continue
+		}
+		start := i
+		for i < len(s) && isFBLabelByte(s[i]) {
+			i++
+		}
+		end := i
+		if i < len(s) && s[i] == '$' {
+			end++
+		}
+		i--
+		if start > 0 && (s[start-1] == '@' || s[start-1] == '&') {
+			continue
+		}
+
+		if word := s[start:end]; strings.ToUpper(word) != word {
+			upper := strings.ToUpper(word)
+			id, n := fbKeywordPrefix(upper, dialect)
+			if n != len(word) && !strings.HasPrefix(upper, "PALET") {
+				f(start, end, false)
+				continue
+			}
+			if idToKeywordMap[id] == "REM" {
+				return
+			}
+			data = idToKeywordMap[id] == "DATA"
+			f(start, end, true)
+			continue
+		}
+
+		// a name runs up to the next keyword, and takes the digits after it
+		name := -1
+		for j := start; j < end; {
+			id, n := fbKeywordPrefix(s[j:end], dialect)
+			if n == 0 {
+				if name < 0 && (s[j] < '0' || s[j] > '9') {
+					name = j
+				}
+				j++
+				continue
+			}
+			if name >= 0 {
+				f(name, j, false)
+				name = -1
+			}
+			keyword := idToKeywordMap[id]
+			if keyword == "REM" {
+				return
+			} else if keyword == "PALET" && j+n < end && (s[j+n] == 'B' || s[j+n] == 'S') {
+				// PALETB and PALETS
+				n++
+			}
+			f(j, j+n, true)
+			j += n
+			if keyword == "DATA" {
+				data = true
+				break
+			}
+		}
+		if name >= 0 {
+			f(name, end, false)
+		}
+	}
+}
+
+// shortenNames writes keywords in upper case and gives names the
+// interpreter would not tell apart, or could not read, short ones.
+func (c *fbCompiler) shortenNames(texts []fbSourceText) []fbSourceText {
+	keywords := make(map[string]bool)
+	for id, kw := range idToKeywordMap {
+		if c.dialect.HasKeyword(id) {
+			keywords[kw] = true
+		}
+	}
+	isShort := func(name string) bool {
+		return len(name) <= 2 && strings.ToUpper(name) == name && !strings.Contains(name, "_")
+	}
+
+	used := make(map[string]bool)
+	var long []string
+	firstUse := make(map[string]fbSourceText) // starting at the name
+	for _, t := range texts {
+		fbNameWords(t.text, c.dialect, func(start, end int, keyword bool) {
+			if keyword {
+				return
+			}
+			word := t.text[start:end]
+			base := strings.TrimSuffix(word, "$")
+			if isShort(base) {
+				used[base] = true
+			} else if _, ok := firstUse[base]; !ok {
+				firstUse[base] = t.slice(start, len(t.text))
+				long = append(long, base)
+			}
+		})
+	}
+
+	mapping := make(map[string]string)
+	var candidates []string
+	for a := 'A'; a <= 'Z'; a++ {
+		candidates = append(candidates, string(a))
+	}
+	for a := 'A'; a <= 'Z'; a++ {
+		for b := 'A'; b <= 'Z'; b++ {
+			candidates = append(candidates, string(a)+string(b))
+		}
+	}
+	next := 0
+	for _, name := range long {
+		for next < len(candidates) && (used[candidates[next]] || keywords[candidates[next]]) {
+			next++
+		}
+		if next == len(candidates) {
+			// left as it is, the name could be mistaken for another
+			c.report(firstUse[name], 0, name, SeverityError, DiagNameCollision, "no short name is left for %s", name)
+			continue
+		}
+		mapping[name] = candidates[next]
+		next++
+	}
+
+	result := make([]fbSourceText, len(texts))
+	for i, t := range texts {
+		r := t.slice(0, 0)
+		start := 0
+		fbNameWords(t.text, c.dialect, func(s, e int, keyword bool) {
+			word := t.text[s:e]
+			replacement := strings.ToUpper(word)
+			if short, ok := mapping[strings.TrimSuffix(word, "$")]; ok && !keyword {
+				replacement = short + word[len(strings.TrimSuffix(word, "$")):]
+				// keep the name apart from letters around it, which
+				// could otherwise be read together as a keyword
+				if s > 0 && isFBIdentifierStart(t.text[s-1]) {
+					replacement = " " + replacement
+				}
+				if e < len(t.text) && isFBIdentifierStart(t.text[e]) {
+					replacement += " "
+				}
+			}
+			if replacement != word {
+				r = r.appendText(t.slice(start, s)).append(replacement, t.column(s))
+				start = e
+			}
+		})
+		result[i] = r.appendText(t.slice(start, len(t.text)))
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStructured(t *testing.T) {
+	source := `score = 0
+WHILE lives > 0 ' main loop
+  GOSUB ReadKey
+  IF key$ = "A" THEN
+    score = score + 10
+    PRINT "HIT"
+  ENDIF
+  IF key$ = "Q" THEN
+    lives = lives - 1
+  ELSE
+    PRINT "SCORE";score
+  ENDIF
+WEND
+
+SUB ReadKey
+  key$ = INKEY$
+ENDSUB
+`
+	program, sourceMap, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "a.bas", Start: 10, Step: 10, Structured: true})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "10 A = 0\n20 GOTO 90\n30 GOSUB 110\n40 IF B$ = \"A\" THEN A = A + 10:PRINT \"HIT\"\n50 IF B$ = \"Q\" THEN 80\n" +
+		"60 PRINT \"SCORE\";A\n70 GOTO 90\n80 C = C - 1\n90 IF C > 0 THEN 30\n100 END\n110 B$ = INKEY$\n120 RETURN\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	expectedMap := "10 a.bas:1\n20 a.bas:2\n30 a.bas:3\n40 a.bas:4\n50 a.bas:8\n60 a.bas:11\n70 a.bas:8\n80 a.bas:9\n90 a.bas:2\n100 a.bas:2\n110 a.bas:16\n120 a.bas:17\n"
+	if sourceMap.String() != expectedMap {
+		t.Errorf("source map mismatch\nexpected:\n%s\nactual:\n%s", expectedMap, sourceMap.String())
+	}
+}
+
+func TestStructuredNames(t *testing.T) {
+	// names which would read as keywords, or which the interpreter could
+	// not tell apart, are renamed; A is taken
+	source := "total = 1:totals = 2:A = 3\nPRINT total;totals;A;&H1F;\"TOTAL\" ' TOTAL\nPALETB 0,1,2,3,4\n"
+	program, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Start: 10, Step: 10, Structured: true})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "10 B = 1:C = 2:A = 3\n20 PRINT B;C;A;&H1F;\"TOTAL\" ' TOTAL\n30 PALETB 0,1,2,3,4\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+}
+
+func TestStructuredTooManyNames(t *testing.T) {
+	// there are fewer than 26*27 one and two letter names
+	var source strings.Builder
+	for i := 0; i < 26*27; i++ {
+		fmt.Fprintf(&source, "name%d = %d\n", i, i)
+	}
+	_, _, diags := FBCompileSource(source.String(), DefaultFBDialect, FBSourceOptions{Filename: "a.bas", Start: 10, Step: 10, Structured: true})
+	if len(diags) == 0 {
+		t.Fatal("expected names to be left without a short name")
+	}
+	for _, d := range diags {
+		if d.Kind != DiagNameCollision || d.Severity != SeverityError {
+			t.Errorf("unexpected diagnostic %v", d)
+		}
+	}
+	if last := diags[len(diags)-1]; last.Line != 26*27 || last.Column != 1 || last.Text != fmt.Sprintf("name%d", 26*27-1) {
+		t.Errorf("expected the last name to be reported, got %v", last)
+	}
+}
+
+func TestStructuredUnspaced(t *testing.T) {
+	// keywords are found inside upper case words, as the tokenizer finds
+	// them, and only the names left are renamed
+	source := "FORI=1TO10:PRINTI:NEXT\nLIVES=3:count=1\nIF LIVES>0THEN\n  PRINTLIVES;count\nENDIF\nIFA=1THEN\n  GOTO10\nENDIF\nDATAGOTO,X\n"
+	program, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Start: 10, Step: 10, Structured: true})
+	if len(diags) != 0 {
+		t.Fatal(diags)
+	}
+	expected := "10 FORI=1TO10:PRINTI:NEXT\n20 B=3:C=1\n30 IF B>0 THEN PRINT B;C\n40 IF A=1 THEN GOTO10\n50 DATAGOTO,X\n"
+	if program.String() != expected {
+		t.Errorf("mismatch\nexpected:\n%s\nactual:\n%s", expected, program.String())
+	}
+	if tokens := program.Lines[0].Tokens; !tokens[0].IsKeyword("FOR") || tokens[1].Kind != TokenRaw || tokens[1].Bytes[0] != 'I' {
+		t.Errorf("expected FOR I, got %v", tokens[:2])
+	}
+}
+
+func TestStructuredDiagnostics(t *testing.T) {
+	source := "IF A THEN\nWHILE X\nELSE\nWEND\nSUB S\nENDSUB\nWEND\n"
+	_, _, diags := FBCompileSource(source, DefaultFBDialect, FBSourceOptions{Filename: "a.bas", Start: 10, Step: 10, Structured: true})
+	expected := []string{
+		"a.bas:1:1: error: IF without ENDIF",
+		"a.bas:3:1: error: ELSE without IF",
+		"a.bas:5:1: error: SUB inside IF",
+		"a.bas:7:1: error: WEND without WHILE",
+	}
+	if len(diags) != len(expected) {
+		t.Fatalf("expected %d diagnostics, got %v", len(expected), diags)
+	}
+	for i, e := range expected {
+		if s := diags[i].Format("a.bas"); s != e {
+			t.Errorf("diagnostic %d: expected %s, got %s", i, e, s)
+		}
+	}
+}
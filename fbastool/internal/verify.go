@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// memoryFile is an in-memory io.WriteSeeker, for audio which is only
+// decoded again.
+type memoryFile struct {
+	data []byte
+	pos  int64
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	end := f.pos + int64(len(p))
+	if end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[f.pos:], p)
+	f.pos = end
+	return len(p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = offset
+	case io.SeekCurrent:
+		f.pos += offset
+	case io.SeekEnd:
+		f.pos = int64(len(f.data)) + offset
+	}
+	if f.pos < 0 {
+		f.pos = 0
+		return 0, errors.New("negative position")
+	}
+	return f.pos, nil
+}
+
+func (f *memoryFile) Bytes() []byte {
+	return f.data
+}
+
+// VerifyTape decodes the WAV file in reader and checks that it contains
+// exactly the given files. Unless options is empty, the audio is degraded
+// with DegradeTape before decoding.
+func VerifyTape(reader io.ReadSeeker, files []FBFile, options DegradeOptions, seed int64) error {
+	if options != (DegradeOptions{}) {
+		degraded := &memoryFile{}
+		err := DegradeTape(reader, degraded, options, seed)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(degraded.Bytes())
+	}
+
+	tapeReader, err := NewTapeReader(reader, NewTapeEncodingInfo())
+	if err != nil {
+		return err
+	}
+
+	for i, expected := range files {
+		actual, err := tapeReader.NextFile()
+		if err != nil {
+			return fmt.Errorf("file %d/%d (%s): %v", i+1, len(files), expected.Info.NameStr(), err)
+		}
+		expectedInfo, _ := expected.Info.MarshalBinary()
+		actualInfo, _ := actual.Info.MarshalBinary()
+		if !bytes.Equal(expectedInfo, actualInfo) {
+			return fmt.Errorf("file %d/%d (%s): header mismatch", i+1, len(files), expected.Info.NameStr())
+		}
+		if !bytes.Equal(expected.Data, actual.Data) {
+			return fmt.Errorf("file %d/%d (%s): data mismatch", i+1, len(files), expected.Info.NameStr())
+		}
+	}
+
+	_, err = tapeReader.NextFile()
+	if err == nil {
+		return fmt.Errorf("found more than %d files", len(files))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func writeTestTape(t *testing.T, files []FBFile) []byte {
+	output := &memoryFile{}
+	tapeWriter, err := NewTapeWriter(output, NewTapeEncodingInfo(), 32000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tapeWriter.WriteSilence(0.25)
+	for _, file := range files {
+		err = tapeWriter.WriteFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tapeWriter.WriteSilence(0.25)
+	err = tapeWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes()
+}
+
+func TestVerifyTape(t *testing.T) {
+	info := FBFileInfo{Type: FileTypeBasic, Length: uint16(len(enriExampleBin)), LoadAddress: 0x6006, ExecutionAddress: 0x2020}
+	info.SetName("ENRI")
+	files := []FBFile{{Info: info, Data: enriExampleBin}}
+	tape := writeTestTape(t, files)
+
+	for _, noise := range []float64{0} {
+		err := VerifyTape(bytes.NewReader(tape), files, DegradeOptions{WhiteNoise: noise}, 1)
+		if err != nil {
+			t.Errorf("noise %.2f: %v", noise, err)
+		}
+	}
+
+	modified := []FBFile{{Info: info, Data: append([]byte{0x12}, enriExampleBin[1:]...)}}
+	if VerifyTape(bytes.NewReader(tape), modified, DegradeOptions{}, 1) == nil {
+		t.Error("modified data passed verification")
+	}
+}
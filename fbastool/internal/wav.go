@@ -80,13 +80,27 @@ func (info *TapeEncodingInfo) getPulseType(pulseSamples int64, sampleRate uint32
 	}
 }
 
+// TapeFileLocation describes where a file was found in a capture, in sample
+// frames from the start of the audio data.
+type TapeFileLocation struct {
+	Start     int64 // start of the information block's sync leader
+	DataStart int64 // start of the data block's sync leader
+	End       int64 // end of the data block
+}
+
 type TapeReader struct {
-	reader            io.ReadSeeker
-	wav               *wav.Decoder
-	encInfo           TapeEncodingInfo
-	buffer            *audio.IntBuffer
-	audioSampleOffset int
-	peekedBit         byte
+	reader             io.ReadSeeker
+	wav                *wav.Decoder
+	encInfo            TapeEncodingInfo
+	buffer             *audio.IntBuffer
+	audioSampleOffset  int
+	peekedBit          byte
+	peekedBitStart     int64
+	samplePos          int64
+	bitStart           int64
+	syncStart          int64
+	lastLocation       TapeFileLocation
+	lastChecksumsValid bool
 }
 
 func NewTapeReader(reader io.ReadSeeker, encInfo TapeEncodingInfo) (*TapeReader, error) {
@@ -120,6 +134,42 @@ func (reader *TapeReader) SetPosition(pos int64) {
 	reader.wav.Seek(pos, io.SeekStart)
 }
 
+func (reader *TapeReader) SampleRate() int {
+	return int(reader.wav.SampleRate)
+}
+
+// SamplePosition returns the number of sample frames read so far.
+func (reader *TapeReader) SamplePosition() int64 {
+	return reader.samplePos
+}
+
+// SkipTo discards audio up to the given sample frame.
+func (reader *TapeReader) SkipTo(pos int64) error {
+	reader.peekedBit = 255
+	for reader.samplePos < pos {
+		count, err := reader.wav.PCMBuffer(reader.buffer)
+		if err != nil {
+			return err
+		} else if count <= 0 {
+			return errors.New("end of file")
+		}
+		reader.samplePos += 1
+	}
+	return nil
+}
+
+// LastFileChecksumsValid reports whether both blocks of the file most
+// recently returned by NextFile had valid checksums.
+func (reader *TapeReader) LastFileChecksumsValid() bool {
+	return reader.lastChecksumsValid
+}
+
+// LastFileLocation returns the location of the file most recently returned
+// by NextFile.
+func (reader *TapeReader) LastFileLocation() TapeFileLocation {
+	return reader.lastLocation
+}
+
 // TODO: Handle non-pristine tapes.
 func (reader *TapeReader) nextPulse() (int64, error) {
 	pos := int64(0)
@@ -140,6 +190,7 @@ func (reader *TapeReader) nextPulse() (int64, error) {
 		}
 
 		pos += 1
+		reader.samplePos += 1
 
 		if prevSample != -1000000 {
 			if prevSample < 0 && sample >= 0 {
@@ -160,14 +211,17 @@ func (reader *TapeReader) nextPulse() (int64, error) {
 
 func (reader *TapeReader) RewindBit(bit byte) {
 	reader.peekedBit = bit
+	reader.peekedBitStart = reader.bitStart
 }
 
 func (reader *TapeReader) NextBit() (byte, error) {
 	if reader.peekedBit != 255 {
 		v := reader.peekedBit
 		reader.peekedBit = 255
+		reader.bitStart = reader.peekedBitStart
 		return v, nil
 	}
+	reader.bitStart = reader.samplePos
 	pulse, err := reader.nextPulse()
 	if err != nil {
 		return 255, err
@@ -262,11 +316,13 @@ func (reader *TapeReader) NextBytesWithChecksum(len int) ([]byte, uint16, error)
 	return data, checksum, nil
 }
 
-func checkChecksumAndPrint(data []byte, name string, checksum uint16) {
+func checkChecksumAndPrint(data []byte, name string, checksum uint16) bool {
 	actualChecksum := CalcDataChecksum(data)
 	if actualChecksum != checksum {
 		fmt.Fprintf(os.Stderr, "warning: block %s has invalid checksum %d != %d\n", name, checksum, actualChecksum)
+		return false
 	}
+	return true
 }
 
 func (reader *TapeReader) NextFile() (*FBFile, error) {
@@ -277,6 +333,7 @@ func (reader *TapeReader) NextFile() (*FBFile, error) {
 	if blockType != RawBlockInfo {
 		return nil, errors.New("invalid block type (expected information)")
 	}
+	location := TapeFileLocation{Start: reader.syncStart}
 
 	err = reader.VerifyBit(1)
 	if err != nil {
@@ -287,7 +344,7 @@ func (reader *TapeReader) NextFile() (*FBFile, error) {
 	if err != nil {
 		return nil, fmt.Errorf("block read error: %v", err)
 	}
-	checkChecksumAndPrint(fbInfoData, "information", fbInfoChecksum)
+	checksumsValid := checkChecksumAndPrint(fbInfoData, "information", fbInfoChecksum)
 
 	err = reader.VerifyBit(1)
 	if err != nil {
@@ -304,6 +361,7 @@ func (reader *TapeReader) NextFile() (*FBFile, error) {
 	if blockType != RawBlockData {
 		return nil, errors.New("invalid block type (expected data)")
 	}
+	location.DataStart = reader.syncStart
 
 	err = reader.VerifyBit(1)
 	if err != nil {
@@ -314,7 +372,9 @@ func (reader *TapeReader) NextFile() (*FBFile, error) {
 	if err != nil {
 		return nil, fmt.Errorf("block read error: %v", err)
 	}
-	checkChecksumAndPrint(fbDataData, "data", fbDataChecksum)
+	if !checkChecksumAndPrint(fbDataData, "data", fbDataChecksum) {
+		checksumsValid = false
+	}
 
 	// don't check the final postlude
 	/* err = reader.VerifyBit(1)
@@ -322,6 +382,10 @@ func (reader *TapeReader) NextFile() (*FBFile, error) {
 		return nil, fmt.Errorf("block postlude error: %v", err)
 	} */
 
+	location.End = reader.samplePos
+	reader.lastLocation = location
+	reader.lastChecksumsValid = checksumsValid
+
 	return &FBFile{
 		Info: fbInfo,
 		Data: fbDataData,
@@ -334,6 +398,7 @@ func (reader *TapeReader) SyncToBlock() (RawBlockType, error) {
 	bitCount := 0
 	firstBitCount := 0
 	secondBitCount := 0
+	runStart := reader.samplePos
 
 	for {
 		bit, err := reader.NextBit()
@@ -352,6 +417,7 @@ func (reader *TapeReader) SyncToBlock() (RawBlockType, error) {
 			case 0: /* syncing */
 				if currentBit == 0 && bitCount >= reader.encInfo.SyncMinPulseCount {
 					state = 1
+					reader.syncStart = runStart
 				}
 			case 1: /* 1 */
 				firstBitCount = bitCount
@@ -370,16 +436,19 @@ func (reader *TapeReader) SyncToBlock() (RawBlockType, error) {
 			}
 			bitCount = 0
 			currentBit = bit
+			runStart = reader.bitStart
 		}
 	}
 }
 
 type TapeWriter struct {
-	writer      io.WriteSeeker
-	wav         *wav.Encoder
-	wavBuffer   audio.IntBuffer
-	encInfo     TapeEncodingInfo
-	freqResidue float64
+	writer         io.WriteSeeker
+	wav            *wav.Encoder
+	wavBuffer      audio.IntBuffer
+	encInfo        TapeEncodingInfo
+	freqResidue    float64
+	samplesWritten int64
+	markers        []TapeMarker
 }
 
 func NewTapeWriter(writer io.WriteSeeker, encInfo TapeEncodingInfo, frequency int) (*TapeWriter, error) {
@@ -407,6 +476,7 @@ func (writer *TapeWriter) WriteSilence(length float64) error {
 	for i := 0; i < samples; i++ {
 		writer.wavBuffer.Data[i] = 128
 	}
+	writer.samplesWritten += int64(samples)
 	return writer.wav.Write(&writer.wavBuffer)
 }
 
@@ -422,6 +492,7 @@ func (writer *TapeWriter) WritePulse(length int) error {
 	for i := 0; i < samples; i++ {
 		writer.wavBuffer.Data[samples+i] = 96
 	}
+	writer.samplesWritten += int64(samples * 2)
 	return writer.wav.Write(&writer.wavBuffer)
 }
 
@@ -503,7 +574,13 @@ func (writer *TapeWriter) writeSyncBlock(pulseCount int) error {
 	return nil
 }
 
+// Markers returns the cue points of the files written so far.
+func (writer *TapeWriter) Markers() []TapeMarker {
+	return writer.markers
+}
+
 func (writer *TapeWriter) WriteFile(file FBFile) error {
+	location := TapeFileLocation{Start: writer.samplesWritten}
 	err := writer.writeSyncBlock(40)
 	if err != nil {
 		return err
@@ -529,6 +606,7 @@ func (writer *TapeWriter) WriteFile(file FBFile) error {
 		return err
 	}
 
+	location.DataStart = writer.samplesWritten
 	err = writer.writeSyncBlock(20)
 	if err != nil {
 		return err
@@ -549,9 +627,14 @@ func (writer *TapeWriter) WriteFile(file FBFile) error {
 		return err
 	}
 
+	writer.markers = append(writer.markers, NewTapeMarkers(file, location)...)
 	return nil
 }
 
 func (writer *TapeWriter) Close() error {
+	err := writeTapeMarkers(writer.wav, writer.samplesWritten, writer.markers)
+	if err != nil {
+		return err
+	}
 	return writer.wav.Close()
 }
@@ -0,0 +1,311 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-audio/wav"
+)
+
+// TapeMarker is a cue point marking the start of a block's sync leader.
+type TapeMarker struct {
+	Position uint32 // in sample frames
+	Block    RawBlockType
+	Name     string
+	Type     FBFileType
+	Checksum uint16
+}
+
+func (m TapeMarker) label() string {
+	if m.Block == RawBlockInfo {
+		return m.Name + ": header"
+	} else if m.Block == RawBlockData {
+		return m.Name + ": data"
+	} else {
+		return m.Name
+	}
+}
+
+func (m TapeMarker) note() string {
+	return fmt.Sprintf("type=%v checksum=%04X", m.Type, m.Checksum)
+}
+
+// NewTapeMarkers returns the header and data markers for a file found at
+// the given location.
+func NewTapeMarkers(file FBFile, location TapeFileLocation) []TapeMarker {
+	infoData, _ := file.Info.MarshalBinary()
+	return []TapeMarker{
+		{
+			Position: uint32(location.Start),
+			Block:    RawBlockInfo,
+			Name:     file.Info.NameStr(),
+			Type:     file.Info.Type,
+			Checksum: CalcDataChecksum(infoData),
+		},
+		{
+			Position: uint32(location.DataStart),
+			Block:    RawBlockData,
+			Name:     file.Info.NameStr(),
+			Type:     file.Info.Type,
+			Checksum: CalcDataChecksum(file.Data),
+		},
+	}
+}
+
+// NextTapeMarker returns the first file header marker after the given
+// sample frame, where decoding can resume after an error.
+func NextTapeMarker(markers []TapeMarker, pos int64) (TapeMarker, bool) {
+	for _, m := range markers {
+		if m.Block == RawBlockInfo && int64(m.Position) > pos {
+			return m, true
+		}
+	}
+	return TapeMarker{}, false
+}
+
+// CheckTapeMarker returns an error if the marker of the same block type
+// nearest to a decoded block names a different file or checksum.
+func CheckTapeMarker(found TapeMarker, markers []TapeMarker) error {
+	var nearest *TapeMarker
+	nearestDistance := int64(0)
+	for i, m := range markers {
+		if m.Block != found.Block {
+			continue
+		}
+		distance := int64(m.Position) - int64(found.Position)
+		if distance < 0 {
+			distance = -distance
+		}
+		if nearest == nil || distance < nearestDistance {
+			nearest = &markers[i]
+			nearestDistance = distance
+		}
+	}
+	if nearest != nil && (nearest.Name != found.Name || nearest.Checksum != found.Checksum) {
+		return fmt.Errorf("%s (checksum %04X) does not match marker %s (checksum %04X)", found.Name, found.Checksum, nearest.Name, nearest.Checksum)
+	}
+	return nil
+}
+
+func appendSubChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)%2 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func appendTextChunk(buf *bytes.Buffer, id string, cueId uint32, text string) {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, cueId)
+	data.WriteString(text)
+	data.WriteByte(0)
+	appendSubChunk(buf, id, data.Bytes())
+}
+
+// encodeTapeMarkers returns a "cue " chunk followed by a "LIST" chunk of
+// type "adtl" carrying the label and note of every marker.
+func encodeTapeMarkers(markers []TapeMarker) []byte {
+	var cue bytes.Buffer
+	binary.Write(&cue, binary.LittleEndian, uint32(len(markers)))
+	for i, m := range markers {
+		binary.Write(&cue, binary.LittleEndian, uint32(i+1))
+		binary.Write(&cue, binary.LittleEndian, m.Position)
+		cue.WriteString("data")
+		binary.Write(&cue, binary.LittleEndian, uint32(0))
+		binary.Write(&cue, binary.LittleEndian, uint32(0))
+		binary.Write(&cue, binary.LittleEndian, m.Position)
+	}
+
+	var adtl bytes.Buffer
+	adtl.WriteString("adtl")
+	for i, m := range markers {
+		appendTextChunk(&adtl, "labl", uint32(i+1), m.label())
+		appendTextChunk(&adtl, "note", uint32(i+1), m.note())
+	}
+
+	var buf bytes.Buffer
+	appendSubChunk(&buf, "cue ", cue.Bytes())
+	appendSubChunk(&buf, "LIST", adtl.Bytes())
+	return buf.Bytes()
+}
+
+func tapeMarkersMetadata(markers []TapeMarker) *wav.Metadata {
+	var names []string
+	var comments []string
+	for _, m := range markers {
+		if m.Block == RawBlockInfo {
+			names = append(names, m.Name)
+		}
+		comments = append(comments, m.label()+" ("+m.note()+")")
+	}
+	return &wav.Metadata{
+		Title:    strings.Join(names, ", "),
+		Comments: strings.Join(comments, "; "),
+		Software: "fbastool",
+	}
+}
+
+// writeTapeMarkers appends the markers to an encoder which has finished
+// writing dataSize bytes of audio data. The LIST/INFO chunk is written by
+// the encoder when it is closed.
+func writeTapeMarkers(encoder *wav.Encoder, dataSize int64, markers []TapeMarker) error {
+	if len(markers) == 0 {
+		return nil
+	}
+	if dataSize%2 == 1 {
+		err := encoder.AddLE(uint8(0))
+		if err != nil {
+			return err
+		}
+	}
+	err := encoder.AddLE(encodeTapeMarkers(markers))
+	if err != nil {
+		return err
+	}
+	encoder.Metadata = tapeMarkersMetadata(markers)
+	return nil
+}
+
+func parseTapeMarkerLabel(m *TapeMarker, label string) {
+	if strings.HasSuffix(label, ": header") {
+		m.Block = RawBlockInfo
+		m.Name = strings.TrimSuffix(label, ": header")
+	} else if strings.HasSuffix(label, ": data") {
+		m.Block = RawBlockData
+		m.Name = strings.TrimSuffix(label, ": data")
+	} else {
+		m.Name = label
+	}
+}
+
+func parseTapeMarkerNote(m *TapeMarker, note string) {
+	for _, field := range strings.Fields(note) {
+		if strings.HasPrefix(field, "type=") {
+			switch strings.TrimPrefix(field, "type=") {
+			case FileTypeBasic.String():
+				m.Type = FileTypeBasic
+			case FileTypeBgGraphics.String():
+				m.Type = FileTypeBgGraphics
+			}
+		} else if strings.HasPrefix(field, "checksum=") {
+			fmt.Sscanf(strings.TrimPrefix(field, "checksum="), "%X", &m.Checksum)
+		}
+	}
+}
+
+func readTextChunks(data []byte, markers map[uint32]*TapeMarker) {
+	for len(data) >= 8 {
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			return
+		}
+		if size >= 4 {
+			m, ok := markers[binary.LittleEndian.Uint32(data[0:4])]
+			text := strings.TrimRight(string(data[4:size]), "\x00")
+			if ok && id == "labl" {
+				parseTapeMarkerLabel(m, text)
+			} else if ok && id == "note" {
+				parseTapeMarkerNote(m, text)
+			}
+		}
+		size += size % 2
+		if size > len(data) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// ReadTapeMarkers reads the cue points of a WAV file, along with the
+// labels and notes written by TapeWriter. The reader is rewound afterwards.
+func ReadTapeMarkers(reader io.ReadSeeker) ([]TapeMarker, error) {
+	defer reader.Seek(0, io.SeekStart)
+
+	_, err := reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 12)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, errors.New("could not read wave file")
+	}
+
+	var order []uint32
+	markers := make(map[uint32]*TapeMarker)
+	var lists [][]byte
+
+	for {
+		_, err = io.ReadFull(reader, header[0:8])
+		if err != nil {
+			break
+		}
+		id := string(header[0:4])
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+		if id == "cue " || id == "LIST" {
+			data := make([]byte, size)
+			_, err = io.ReadFull(reader, data)
+			if err != nil {
+				return nil, err
+			}
+			if id == "cue " && len(data) >= 4 {
+				count := int(binary.LittleEndian.Uint32(data[0:4]))
+				for i := 0; i < count && 4+(i+1)*24 <= len(data); i++ {
+					point := data[4+i*24:]
+					cueId := binary.LittleEndian.Uint32(point[0:4])
+					order = append(order, cueId)
+					markers[cueId] = &TapeMarker{Position: binary.LittleEndian.Uint32(point[20:24])}
+				}
+			} else if id == "LIST" && len(data) >= 4 && string(data[0:4]) == "adtl" {
+				lists = append(lists, data[4:])
+			}
+			size = size % 2
+		} else {
+			size += size % 2
+		}
+		_, err = reader.Seek(size, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, list := range lists {
+		readTextChunks(list, markers)
+	}
+	result := make([]TapeMarker, len(order))
+	for i, cueId := range order {
+		result[i] = *markers[cueId]
+	}
+	return result, nil
+}
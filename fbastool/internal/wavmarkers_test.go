@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func writeMarkedTestTape(t *testing.T, names ...string) ([]byte, []TapeMarker) {
+	output := &memoryFile{}
+	tapeWriter, err := NewTapeWriter(output, NewTapeEncodingInfo(), 32000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tapeWriter.WriteSilence(0.25)
+	for _, name := range names {
+		info := FBFileInfo{Type: FileTypeBasic, Length: uint16(len(enriExampleBin)), LoadAddress: 0x6006, ExecutionAddress: 0x2020}
+		info.SetName(name)
+		err = tapeWriter.WriteFile(FBFile{Info: info, Data: enriExampleBin})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tapeWriter.WriteSilence(0.25)
+	markers := tapeWriter.Markers()
+	err = tapeWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.Bytes(), markers
+}
+
+func TestTapeMarkersRoundTrip(t *testing.T) {
+	tape, markers := writeMarkedTestTape(t, "ONE", "TWO")
+	if len(markers) != 4 {
+		t.Fatalf("expected 4 markers, found %d", len(markers))
+	}
+	if list := wavChunk(t, tape, "LIST"); !bytes.HasPrefix(list, []byte("adtl")) {
+		t.Errorf("expected an adtl list, found %q", list[:4])
+	}
+
+	read, err := ReadTapeMarkers(bytes.NewReader(tape))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, markers) {
+		t.Errorf("expected %+v, read %+v", markers, read)
+	}
+
+	// the chunks alone decode the same way
+	read, err = ReadTapeMarkers(bytes.NewReader(append([]byte("RIFF\x00\x00\x00\x00WAVE"), encodeTapeMarkers(markers)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, markers) {
+		t.Errorf("expected %+v, read %+v", markers, read)
+	}
+}
+
+func TestTapeMarkersInSegment(t *testing.T) {
+	tape, markers := writeMarkedTestTape(t, "ONE", "TWO")
+	start, end := int64(markers[2].Position)-100, int64(len(wavChunk(t, tape, "data")))
+	segment := &memoryFile{}
+	err := CopyWavSegment(bytes.NewReader(tape), segment, start, end, markers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadTapeMarkers(bytes.NewReader(segment.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 {
+		t.Fatalf("expected the 2 markers of TWO, found %+v", read)
+	}
+	for i, m := range read {
+		expected := markers[2+i]
+		expected.Position -= uint32(start)
+		if m != expected {
+			t.Errorf("expected %+v, found %+v", expected, m)
+		}
+	}
+}
+
+func TestSkipToNextTapeMarker(t *testing.T) {
+	tape, markers := writeMarkedTestTape(t, "ONE", "TWO")
+
+	// silence part of the header block of ONE
+	data := wavChunk(t, tape, "data")
+	damage := int(markers[1].Position) - 20000
+	for i := damage; i < damage+600; i++ {
+		data[i] = 0x80
+	}
+
+	reader, err := NewTapeReader(bytes.NewReader(tape), NewTapeEncodingInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.NextFile()
+	if err == nil {
+		t.Fatal("expected a decode error")
+	}
+
+	next, ok := NextTapeMarker(markers, reader.SamplePosition())
+	if !ok || next != markers[2] {
+		t.Fatalf("expected to resume at %+v, found %+v", markers[2], next)
+	}
+	err = reader.SkipTo(int64(next.Position))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := reader.NextFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Info.NameStr() != "TWO" {
+		t.Errorf("expected TWO after skipping, found %s", file.Info.NameStr())
+	}
+	for _, m := range NewTapeMarkers(*file, reader.LastFileLocation()) {
+		if err := CheckTapeMarker(m, markers); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, ok := NextTapeMarker(markers, int64(markers[2].Position)); ok {
+		t.Error("expected no marker after the last file")
+	}
+}
+
+func TestCheckTapeMarker(t *testing.T) {
+	markers := []TapeMarker{
+		{Position: 1000, Block: RawBlockInfo, Name: "ONE", Checksum: 0x1234},
+		{Position: 9000, Block: RawBlockInfo, Name: "TWO", Checksum: 0x5678},
+	}
+	if err := CheckTapeMarker(TapeMarker{Position: 8500, Block: RawBlockInfo, Name: "TWO", Checksum: 0x5678}, markers); err != nil {
+		t.Error(err)
+	}
+	if err := CheckTapeMarker(TapeMarker{Position: 8500, Block: RawBlockInfo, Name: "TWO", Checksum: 0x0000}, markers); err == nil {
+		t.Error("expected a checksum mismatch")
+	}
+	if err := CheckTapeMarker(TapeMarker{Position: 1200, Block: RawBlockInfo, Name: "TWO", Checksum: 0x5678}, markers); err == nil {
+		t.Error("expected a name mismatch")
+	}
+	if err := CheckTapeMarker(TapeMarker{Position: 1200, Block: RawBlockData, Name: "TWO"}, markers); err != nil {
+		t.Errorf("expected no data marker to compare against, found %v", err)
+	}
+}
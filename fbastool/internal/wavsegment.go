@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// CopyWavSegment copies the sample frames in [start, end) of the WAV file in
+// src to a new WAV file in dst. The samples are copied byte for byte, in
+// their original format. The range is clamped to the length of the source
+// audio. Markers, positioned relative to src, are moved to match the
+// segment.
+func CopyWavSegment(src io.ReadSeeker, dst io.WriteSeeker, start, end int64, markers []TapeMarker) error {
+	_, err := src.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	decoder := wav.NewDecoder(src)
+	decoder.ReadInfo()
+	if decoder.Err() != nil || decoder.NumChans < 1 || decoder.BitDepth < 8 {
+		return errors.New("could not read wave file")
+	}
+	err = decoder.FwdToPCM()
+	if err != nil {
+		return err
+	}
+	dataStart, err := src.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	frameSize := int64(decoder.NumChans) * int64(decoder.BitDepth/8)
+	frames := decoder.PCMLen() / frameSize
+	if start < 0 {
+		start = 0
+	}
+	if end > frames {
+		end = frames
+	}
+	if end < start {
+		end = start
+	}
+	data := make([]byte, (end-start)*frameSize)
+	if _, err := src.Seek(dataStart+start*frameSize, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(src, data); err != nil {
+		return err
+	}
+
+	// an empty buffer starts the data chunk, which the samples are then
+	// added to as they are
+	encoder := wav.NewEncoder(dst, int(decoder.SampleRate), int(decoder.BitDepth), int(decoder.NumChans), int(decoder.WavAudioFormat))
+	err = encoder.Write(&audio.IntBuffer{Format: &audio.Format{NumChannels: int(decoder.NumChans), SampleRate: int(decoder.SampleRate)}})
+	if err != nil {
+		return err
+	}
+	// the size of the data chunk is the last field written so far
+	dataSizeOffset := int64(encoder.WrittenBytes) - 4
+	err = encoder.AddLE(data)
+	if err != nil {
+		return err
+	}
+
+	var segmentMarkers []TapeMarker
+	for _, m := range markers {
+		if int64(m.Position) >= start && int64(m.Position) < end {
+			m.Position -= uint32(start)
+			segmentMarkers = append(segmentMarkers, m)
+		}
+	}
+	err = writeTapeMarkers(encoder, int64(len(data)), segmentMarkers)
+	if err != nil {
+		return err
+	}
+	err = encoder.Close()
+	if err != nil {
+		return err
+	}
+
+	// the encoder only counts samples given to it by Write
+	if _, err := dst.Seek(dataSizeOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := binary.Write(dst, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = dst.Seek(0, io.SeekEnd)
+	return err
+}
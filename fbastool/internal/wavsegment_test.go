@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Adrian Siekierka
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// wavChunk returns the contents of the first chunk with the given ID in a
+// WAV file.
+func wavChunk(t *testing.T, data []byte, id string) []byte {
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if string(data[pos:pos+4]) == id {
+			return data[pos+8 : pos+8+size]
+		}
+		pos += 8 + size + size%2
+	}
+	t.Fatalf("no %q chunk", id)
+	return nil
+}
+
+func readTestTapeFiles(t *testing.T, tape []byte) ([]FBFile, []TapeFileLocation) {
+	reader, err := NewTapeReader(bytes.NewReader(tape), NewTapeEncodingInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []FBFile
+	var locations []TapeFileLocation
+	for {
+		file, err := reader.NextFile()
+		if err != nil {
+			return files, locations
+		}
+		files = append(files, *file)
+		locations = append(locations, reader.LastFileLocation())
+	}
+}
+
+func TestCopyWavSegment(t *testing.T) {
+	var files []FBFile
+	for _, name := range []string{"ONE", "TWO"} {
+		info := FBFileInfo{Type: FileTypeBasic, Length: uint16(len(enriExampleBin)), LoadAddress: 0x6006, ExecutionAddress: 0x2020}
+		info.SetName(name)
+		files = append(files, FBFile{Info: info, Data: enriExampleBin})
+	}
+	tape := writeTestTape(t, files)
+	_, locations := readTestTapeFiles(t, tape)
+	if len(locations) != 2 {
+		t.Fatalf("expected 2 files, found %d", len(locations))
+	}
+
+	location := locations[1]
+	start, end := location.Start-100, location.End+100
+	segment := &memoryFile{}
+	err := CopyWavSegment(bytes.NewReader(tape), segment, start, end, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 8-bit mono: one byte per sample frame
+	expected := wavChunk(t, tape, "data")[start:end]
+	if actual := wavChunk(t, segment.Bytes(), "data"); !bytes.Equal(actual, expected) {
+		t.Errorf("segment holds %d bytes differing from the %d source bytes", len(actual), len(expected))
+	}
+
+	segmentFiles, segmentLocations := readTestTapeFiles(t, segment.Bytes())
+	if len(segmentFiles) != 1 || segmentFiles[0].Info.NameStr() != "TWO" || !bytes.Equal(segmentFiles[0].Data, files[1].Data) {
+		t.Fatalf("unexpected files in segment: %v", segmentFiles)
+	}
+	shifted := TapeFileLocation{Start: location.Start - start, DataStart: location.DataStart - start, End: location.End - start}
+	if segmentLocations[0] != shifted {
+		t.Errorf("expected the file at %+v in the segment, found it at %+v", shifted, segmentLocations[0])
+	}
+
+	// the range is clamped to the source audio
+	segment = &memoryFile{}
+	err = CopyWavSegment(bytes.NewReader(tape), segment, location.Start, 1<<40, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := wavChunk(t, segment.Bytes(), "data"); !bytes.Equal(actual, wavChunk(t, tape, "data")[location.Start:]) {
+		t.Error("segment to the end of the source differs from the source")
+	}
+}